@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"duarteocarmo/ambrosio/modes"
+	"duarteocarmo/ambrosio/reminders"
+	"duarteocarmo/ambrosio/telegram"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Role is who may use a command.
+type Role int
+
+const (
+	// RoleMember is anyone allowed to talk to the bot in a group of TELEGRAM_GROUPS.
+	RoleMember Role = iota
+	// RoleOwner is TELEGRAM_USERNAME.
+	RoleOwner
+)
+
+type Subcommand struct {
+	Name string
+	// Usage describes the arguments after the subcommand, if any.
+	Usage       string
+	Description string
+}
+
+// Command is a bot command. /help, the per-command help and the menu registered with
+// Telegram are generated from the registry of commands.
+type Command struct {
+	Name    string
+	Aliases []string
+	// Usage describes the arguments after the command, if any.
+	Usage       string
+	Description string
+	// Details follow the description in the command's help, like examples.
+	Details     string
+	Subcommands []Subcommand
+	// RequiresSubcommand shows the command's help instead of running it when it is
+	// sent without one of its subcommands.
+	RequiresSubcommand bool
+	Role               Role
+	// Private commands start flows, which read every following message, so they
+	// only run in the private chat.
+	Private bool
+	Run     func(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, stores *Stores) error
+}
+
+// Help explains the command, its aliases and its subcommands.
+func (c *Command) Help() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - %s", synopsis("/"+c.Name, c.Usage), c.Description)
+	if len(c.Aliases) > 0 {
+		fmt.Fprintf(&b, "\nAlso /%s.", strings.Join(c.Aliases, ", /"))
+	}
+	if c.Private {
+		b.WriteString("\nOnly in our private chat.")
+	}
+	if c.Details != "" {
+		b.WriteString("\n\n" + c.Details)
+	}
+	for i, s := range c.Subcommands {
+		if i == 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "\n%s - %s", synopsis("/"+c.Name+" "+s.Name, s.Usage), s.Description)
+	}
+	return b.String()
+}
+
+// Keyboard has a button for each subcommand without arguments, pressed instead of
+// typing it.
+func (c *Command) Keyboard() tgbotapi.InlineKeyboardMarkup {
+	row := []tgbotapi.InlineKeyboardButton{}
+	for _, s := range c.Subcommands {
+		if s.Usage == "" {
+			row = append(row, modes.CommandButton(s.Name, c.Name, s.Name))
+		}
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(row)
+}
+
+func (c *Command) hasSubcommand(name string) bool {
+	for _, s := range c.Subcommands {
+		if strings.EqualFold(s.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func synopsis(command, usage string) string {
+	if usage == "" {
+		return command
+	}
+	return command + " " + usage
+}
+
+// Registry looks commands up by name or alias.
+type Registry struct {
+	commands []*Command
+	byName   map[string]*Command
+}
+
+// NewRegistry panics on names used twice, which is a programming error.
+func NewRegistry(commands ...*Command) *Registry {
+	r := &Registry{commands: commands, byName: map[string]*Command{}}
+	for _, c := range commands {
+		for _, name := range append([]string{c.Name}, c.Aliases...) {
+			if _, ok := r.byName[name]; ok {
+				panic(fmt.Sprintf("command /%s registered twice", name))
+			}
+			r.byName[name] = c
+		}
+	}
+	return r
+}
+
+func (r *Registry) Lookup(name string) (*Command, bool) {
+	c, ok := r.byName[strings.ToLower(name)]
+	return c, ok
+}
+
+// Help lists the commands role may use in a private chat or in a group.
+func (r *Registry) Help(role Role, private bool) string {
+	var b strings.Builder
+	b.WriteString("Available commands:\n")
+	for _, c := range r.available(role, private) {
+		fmt.Fprintf(&b, "\n/%s - %s", c.Name, c.Description)
+	}
+	fmt.Fprintf(&b, "\n\nSend /%s <command> to learn more about one.", HelpCommand)
+	return b.String()
+}
+
+// BotCommands is the command menu shown to the owner in private chats or in groups.
+func (r *Registry) BotCommands(private bool) []tgbotapi.BotCommand {
+	var menu []tgbotapi.BotCommand
+	for _, c := range r.available(RoleOwner, private) {
+		menu = append(menu, tgbotapi.BotCommand{Command: c.Name, Description: c.Description})
+	}
+	return menu
+}
+
+func (r *Registry) available(role Role, private bool) []*Command {
+	var commands []*Command
+	for _, c := range r.commands {
+		if c.Role <= role && (private || !c.Private) {
+			commands = append(commands, c)
+		}
+	}
+	return commands
+}
+
+// registerCommands sets the command menus Telegram shows in private chats and groups.
+func registerCommands(bot *tgbotapi.BotAPI) error {
+	for _, menu := range []tgbotapi.SetMyCommandsConfig{
+		tgbotapi.NewSetMyCommandsWithScope(tgbotapi.NewBotCommandScopeAllPrivateChats(), registry.BotCommands(true)...),
+		tgbotapi.NewSetMyCommandsWithScope(tgbotapi.NewBotCommandScopeAllGroupChats(), registry.BotCommands(false)...),
+	} {
+		if _, err := bot.Request(menu); err != nil {
+			return fmt.Errorf("error registering commands: %w", err)
+		}
+	}
+	return nil
+}
+
+// registry holds every command. /help is answered from the registry itself, so it
+// has no Run.
+var registry = NewRegistry(
+	&Command{
+		Name:        AssistantMode,
+		Aliases:     []string{"a"},
+		Description: "Talk to the model",
+		Subcommands: []Subcommand{
+			{Name: modes.ChatMode, Description: "Chat with text, voice, photos, documents and links"},
+			{Name: modes.PhotoGenMode, Description: "Generate images from a prompt"},
+			{Name: modes.AskMode, Description: "Answer questions from your notes, photos and documents"},
+		},
+		RequiresSubcommand: true,
+		Role:               RoleOwner,
+		Private:            true,
+		Run: func(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, stores *Stores) error {
+			return modes.AssistantMode(ctx, update, bot, &modes.Knowledge{Index: stores.Index, Notes: stores.Notes})
+		},
+	},
+	&Command{
+		Name:        PhotoMode,
+		Aliases:     []string{"p"},
+		Description: "Add photos to the gallery or delete them",
+		Subcommands: []Subcommand{
+			{Name: modes.PhotoModeCreate, Description: "Upload a photo with a caption and location"},
+			{Name: modes.PhotoModeDelete, Description: "Delete a photo by its ID"},
+		},
+		RequiresSubcommand: true,
+		Role:               RoleOwner,
+		Private:            true,
+		Run: func(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, stores *Stores) error {
+			return modes.PhotoMode(ctx, update, bot)
+		},
+	},
+	&Command{
+		Name:        RemindCommand,
+		Usage:       "<when> <what>",
+		Description: "Set a reminder, like /remind in 2h call mum",
+		Details:     reminders.Usage,
+		Role:        RoleOwner,
+		Run: func(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, stores *Stores) error {
+			return modes.RemindCommand(ctx, update, bot, stores.Reminders)
+		},
+	},
+	&Command{
+		Name:        RemindersCommand,
+		Description: "List pending reminders",
+		Role:        RoleOwner,
+		Run: func(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, stores *Stores) error {
+			return modes.RemindersCommand(ctx, update, bot, stores.Reminders)
+		},
+	},
+	&Command{
+		Name:        TimezoneCommand,
+		Usage:       "[zone]",
+		Description: "Show or set the timezone of reminders",
+		Role:        RoleOwner,
+		Run: func(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, stores *Stores) error {
+			return modes.TimezoneCommand(ctx, update, bot, stores.Reminders)
+		},
+	},
+	&Command{
+		Name:        NoteCommand,
+		Usage:       "<text>",
+		Description: "Save a note, #tags included",
+		Role:        RoleOwner,
+		Run: func(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, stores *Stores) error {
+			return modes.NoteCommand(ctx, update, bot, stores.Notes)
+		},
+	},
+	&Command{
+		Name:        NotesCommand,
+		Usage:       "[#tag]",
+		Description: "List recent notes, or those with a tag",
+		Subcommands: []Subcommand{
+			{Name: modes.NotesSearch, Usage: "<words>", Description: "Find notes and to-dos"},
+			{Name: modes.NotesExport, Description: "Send everything as Markdown"},
+		},
+		Role: RoleOwner,
+		Run: func(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, stores *Stores) error {
+			return modes.NotesCommand(ctx, update, bot, stores.Notes)
+		},
+	},
+	&Command{
+		Name:        TodoCommand,
+		Usage:       "[text]",
+		Description: "Add a to-do, or list and complete them",
+		Subcommands: []Subcommand{
+			{Name: modes.TodoAdd, Usage: "<text>", Description: "Add a to-do"},
+			{Name: modes.TodoDone, Usage: "<number>", Description: "Mark a to-do as done"},
+			{Name: modes.TodoList, Usage: "[#tag]", Description: "List open to-dos"},
+		},
+		Role: RoleOwner,
+		Run: func(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, stores *Stores) error {
+			return modes.TodoCommand(ctx, update, bot, stores.Notes)
+		},
+	},
+	&Command{
+		Name:        SummarizeCommand,
+		Usage:       "<url>",
+		Description: "Summarise a web page",
+		Role:        RoleMember,
+		Run: func(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, stores *Stores) error {
+			return modes.SummarizeCommand(ctx, update, bot)
+		},
+	},
+	&Command{
+		Name:        HelpCommand,
+		Usage:       "[command]",
+		Description: "List commands, or explain one",
+		Role:        RoleMember,
+	},
+)
@@ -0,0 +1,182 @@
+package geo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	gpsIFDPointerTag = 0x8825
+	gpsLatitudeRef   = 0x0001
+	gpsLatitude      = 0x0002
+	gpsLongitudeRef  = 0x0003
+	gpsLongitude     = 0x0004
+	typeASCII        = 2
+	typeRational     = 5
+)
+
+// ErrNoGPS is returned for images without GPS coordinates in their EXIF data.
+var ErrNoGPS = errors.New("image has no GPS data")
+
+// Coordinates is a position in decimal degrees.
+type Coordinates struct {
+	Latitude  float64
+	Longitude float64
+}
+
+func (c Coordinates) String() string {
+	return fmt.Sprintf("%.5f, %.5f", c.Latitude, c.Longitude)
+}
+
+// GPSFromJPEG reads the GPS position stored in the EXIF segment of a JPEG.
+func GPSFromJPEG(data []byte) (Coordinates, error) {
+	tiff, err := exifSegment(data)
+	if err != nil {
+		return Coordinates{}, err
+	}
+	if len(tiff) < 8 {
+		return Coordinates{}, ErrNoGPS
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return Coordinates{}, fmt.Errorf("invalid TIFF header")
+	}
+
+	r := &tiffReader{data: tiff, order: order}
+
+	ifd0, err := r.readIFD(r.uint32(4))
+	if err != nil {
+		return Coordinates{}, err
+	}
+	pointer, ok := ifd0[gpsIFDPointerTag]
+	if !ok {
+		return Coordinates{}, ErrNoGPS
+	}
+
+	gps, err := r.readIFD(pointer.valueOffset)
+	if err != nil {
+		return Coordinates{}, err
+	}
+
+	lat, err := r.degrees(gps, gpsLatitude, gpsLatitudeRef, "S")
+	if err != nil {
+		return Coordinates{}, err
+	}
+	lon, err := r.degrees(gps, gpsLongitude, gpsLongitudeRef, "W")
+	if err != nil {
+		return Coordinates{}, err
+	}
+
+	return Coordinates{Latitude: lat, Longitude: lon}, nil
+}
+
+// exifSegment returns the TIFF data of the APP1 Exif segment.
+func exifSegment(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, fmt.Errorf("not a JPEG")
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil, ErrNoGPS
+		}
+		marker := data[i+1]
+		// start of scan: no more metadata segments
+		if marker == 0xDA || marker == 0xD9 {
+			return nil, ErrNoGPS
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, ErrNoGPS
+		}
+
+		segment := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+		i = end
+	}
+	return nil, ErrNoGPS
+}
+
+type ifdEntry struct {
+	typ         uint16
+	count       uint32
+	valueOffset uint32
+	raw         []byte
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func (r *tiffReader) uint32(offset uint32) uint32 {
+	if int(offset)+4 > len(r.data) {
+		return 0
+	}
+	return r.order.Uint32(r.data[offset:])
+}
+
+func (r *tiffReader) readIFD(offset uint32) (map[uint16]ifdEntry, error) {
+	if offset == 0 || int(offset)+2 > len(r.data) {
+		return nil, fmt.Errorf("invalid IFD offset")
+	}
+
+	count := int(r.order.Uint16(r.data[offset:]))
+	entries := map[uint16]ifdEntry{}
+	for i := 0; i < count; i++ {
+		start := int(offset) + 2 + i*12
+		if start+12 > len(r.data) {
+			return nil, fmt.Errorf("truncated IFD")
+		}
+		entry := r.data[start : start+12]
+		entries[r.order.Uint16(entry)] = ifdEntry{
+			typ:         r.order.Uint16(entry[2:]),
+			count:       r.order.Uint32(entry[4:]),
+			valueOffset: r.order.Uint32(entry[8:]),
+			raw:         entry[8:12],
+		}
+	}
+	return entries, nil
+}
+
+// degrees converts a degrees/minutes/seconds rational triple to decimal degrees,
+// negated when the reference tag equals negativeRef.
+func (r *tiffReader) degrees(entries map[uint16]ifdEntry, valueTag, refTag uint16, negativeRef string) (float64, error) {
+	value, ok := entries[valueTag]
+	if !ok || value.typ != typeRational || value.count != 3 {
+		return 0, ErrNoGPS
+	}
+
+	offset := int(value.valueOffset)
+	if offset+24 > len(r.data) {
+		return 0, fmt.Errorf("truncated GPS data")
+	}
+
+	var parts [3]float64
+	for i := range parts {
+		num := r.order.Uint32(r.data[offset+i*8:])
+		den := r.order.Uint32(r.data[offset+i*8+4:])
+		if den == 0 {
+			return 0, fmt.Errorf("invalid GPS rational")
+		}
+		parts[i] = float64(num) / float64(den)
+	}
+	degrees := parts[0] + parts[1]/60 + parts[2]/3600
+
+	if ref, ok := entries[refTag]; ok && ref.typ == typeASCII && string(ref.raw[:1]) == negativeRef {
+		degrees = -degrees
+	}
+	return degrees, nil
+}
@@ -0,0 +1,107 @@
+package geo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultUserAgent = "ambrosio-bot"
+
+// Geocoder names the place at a position.
+type Geocoder interface {
+	Reverse(ctx context.Context, c Coordinates) (string, error)
+}
+
+// NominatimGeocoder talks to a Nominatim-compatible /reverse endpoint.
+type NominatimGeocoder struct {
+	URL        string
+	UserAgent  string
+	HTTPClient *http.Client
+}
+
+// NewGeocoder configures a NominatimGeocoder from GEOCODER_URL and GEOCODER_USER_AGENT.
+func NewGeocoder() (Geocoder, error) {
+	baseURL := os.Getenv("GEOCODER_URL")
+	if baseURL == "" {
+		return nil, fmt.Errorf("reverse geocoding is not configured, set GEOCODER_URL")
+	}
+
+	userAgent := os.Getenv("GEOCODER_USER_AGENT")
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+
+	return &NominatimGeocoder{
+		URL:        strings.TrimSuffix(baseURL, "/"),
+		UserAgent:  userAgent,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (n *NominatimGeocoder) Reverse(ctx context.Context, c Coordinates) (string, error) {
+	query := url.Values{}
+	query.Set("format", "jsonv2")
+	query.Set("lat", strconv.FormatFloat(c.Latitude, 'f', 6, 64))
+	query.Set("lon", strconv.FormatFloat(c.Longitude, 'f', 6, 64))
+	query.Set("zoom", "14")
+
+	req, err := http.NewRequestWithContext(ctx, "GET", n.URL+"/reverse?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", n.UserAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := n.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("reverse geocoding failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("reverse geocoding failed: %s", resp.Status)
+	}
+
+	var result struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"display_name"`
+		Address     struct {
+			City    string `json:"city"`
+			Town    string `json:"town"`
+			Village string `json:"village"`
+			Country string `json:"country"`
+		} `json:"address"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode geocoding result: %w", err)
+	}
+
+	var parts []string
+	for _, part := range []string{result.Address.City, result.Address.Town, result.Address.Village} {
+		if part != "" {
+			parts = append(parts, part)
+			break
+		}
+	}
+	if result.Address.Country != "" {
+		parts = append(parts, result.Address.Country)
+	}
+
+	switch {
+	case len(parts) > 0:
+		return strings.Join(parts, ", "), nil
+	case result.Name != "":
+		return result.Name, nil
+	case result.DisplayName != "":
+		return result.DisplayName, nil
+	default:
+		return "", fmt.Errorf("no place found at %s", c)
+	}
+}
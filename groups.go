@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// groupAllowlist maps the groups the bot works in to the users who may talk to it
+// there, besides TELEGRAM_USERNAME who may in every listed group.
+type groupAllowlist map[int64][]string
+
+// parseGroups reads TELEGRAM_GROUPS, a ";" separated list of group chat IDs, each
+// optionally followed by ":" and the "," separated usernames allowed in it, like
+// "-1001234567890:alice,bob;-1009876543210".
+func parseGroups(value string) (groupAllowlist, error) {
+	groups := groupAllowlist{}
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, users, _ := strings.Cut(entry, ":")
+		chatID, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group chat ID %q in TELEGRAM_GROUPS", id)
+		}
+
+		groups[chatID] = nil
+		for _, user := range strings.Split(users, ",") {
+			if user = strings.TrimPrefix(strings.TrimSpace(user), "@"); user != "" {
+				groups[chatID] = append(groups[chatID], user)
+			}
+		}
+	}
+	return groups, nil
+}
+
+func (g groupAllowlist) has(chatID int64) bool {
+	_, ok := g[chatID]
+	return ok
+}
+
+func (g groupAllowlist) allows(chatID int64, user *tgbotapi.User, owner string) bool {
+	if user == nil || !g.has(chatID) {
+		return false
+	}
+	if user.UserName == owner {
+		return true
+	}
+	for _, allowed := range g[chatID] {
+		if strings.EqualFold(user.UserName, allowed) {
+			return true
+		}
+	}
+	return false
+}
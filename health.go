@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"duarteocarmo/ambrosio/metrics"
+	"duarteocarmo/ambrosio/server"
+	"duarteocarmo/ambrosio/storage"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// newServer creates the HTTP server exposing health, readiness and metrics endpoints.
+func newServer(bot *tgbotapi.BotAPI) *server.Server {
+	srv := server.New(os.Getenv("LISTEN_ADDR"))
+	srv.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	srv.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+
+	srv.Handle("/healthz", server.HealthHandler())
+	srv.Handle("/readyz", server.ReadyHandler(map[string]server.Check{
+		"telegram": func(ctx context.Context) error {
+			return withContext(ctx, func() error {
+				_, err := bot.GetMe()
+				return err
+			})
+		},
+		"storage": storage.Ping,
+		"llm": func(ctx context.Context) error {
+			if os.Getenv("TOGETHER_API_KEY") == "" {
+				return fmt.Errorf("TOGETHER_API_KEY not set")
+			}
+			return nil
+		},
+	}))
+	srv.Handle("/metrics", metrics.Handler())
+
+	return srv
+}
+
+// withContext runs f, giving up when ctx is done for calls that take no context.
+func withContext(ctx context.Context, f func() error) error {
+	errs := make(chan error, 1)
+	go func() { errs <- f() }()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// healthcheck probes /healthz of a running instance and returns the process exit code,
+// so the container can be checked without shipping curl in the image.
+func healthcheck() int {
+	addr := os.Getenv("LISTEN_ADDR")
+	if addr == "" {
+		addr = server.DefaultListenAddr
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+
+	scheme := "http"
+	client := &http.Client{Timeout: 5 * time.Second}
+	if os.Getenv("TLS_CERT_FILE") != "" {
+		scheme = "https"
+		// the certificate is for the public name, not localhost, and the probe only
+		// checks that the server answers, so it isn't verified
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	resp, err := client.Get(scheme + "://" + addr + "/healthz")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, resp.Status)
+		return 1
+	}
+	return 0
+}
+
+func updateType(update tgbotapi.Update) string {
+	switch {
+	case update.Message != nil:
+		return "message"
+	case update.EditedMessage != nil:
+		return "edited_message"
+	case update.CallbackQuery != nil:
+		return "callback_query"
+	case update.InlineQuery != nil:
+		return "inline_query"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,65 @@
+// Package jsonfile keeps the data of a store in a JSON file in the data directory,
+// written whole on every change.
+package jsonfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// File is a JSON file holding a store's data.
+type File struct {
+	Path string
+	// Compact leaves out indentation, for files too large to read by hand anyway.
+	Compact bool
+}
+
+// Open returns the file called name in dir, creating the directory if needed.
+func Open(dir, name string) (File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return File{}, fmt.Errorf("error creating data directory: %w", err)
+	}
+	return File{Path: filepath.Join(dir, name)}, nil
+}
+
+// Load decodes the file into v, leaving v as it is when the file does not exist yet.
+func (f File) Load(v interface{}) error {
+	raw, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", f.Path, err)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("error decoding %s: %w", f.Path, err)
+	}
+	return nil
+}
+
+// Save writes v to a temporary file and renames it over the old one, so a crash never
+// leaves a half-written file.
+func (f File) Save(v interface{}) error {
+	var raw []byte
+	var err error
+	if f.Compact {
+		raw, err = json.Marshal(v)
+	} else {
+		raw, err = json.MarshalIndent(v, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+
+	tmp := f.Path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("error saving %s: %w", f.Path, err)
+	}
+	if err := os.Rename(tmp, f.Path); err != nil {
+		return fmt.Errorf("error saving %s: %w", f.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	CorrelationIDKey = "correlation_id"
+	Redacted         = "[REDACTED]"
+)
+
+// botTokenPattern matches Telegram bot tokens even when they were not registered with AddSecret.
+var botTokenPattern = regexp.MustCompile(`\d{6,}:[A-Za-z0-9_-]{30,}`)
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+type contextKey struct{}
+
+// Setup installs the default slog logger, configured by LOG_LEVEL (debug, info, warn, error)
+// and LOG_FORMAT (text or json). DEV mode logs at debug level unless LOG_LEVEL says otherwise.
+func Setup() error {
+	level := slog.LevelInfo
+	if os.Getenv("MODE") == "DEV" {
+		level = slog.LevelDebug
+	}
+	if l := os.Getenv("LOG_LEVEL"); l != "" {
+		if err := level.UnmarshalText([]byte(l)); err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL %q: %w", l, err)
+		}
+	}
+
+	slog.SetDefault(slog.New(NewHandler(os.Stderr, os.Getenv("LOG_FORMAT"), level)))
+	return nil
+}
+
+// NewHandler returns a text or json handler writing to w that redacts secrets from every attribute.
+func NewHandler(w io.Writer, format string, level slog.Leveler) slog.Handler {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.NewJSONHandler(w, opts)
+	default:
+		return slog.NewTextHandler(w, opts)
+	}
+}
+
+// AddSecret registers a value that must never appear in logs.
+func AddSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets = append(secrets, secret)
+}
+
+// Redact removes registered secrets and anything that looks like a bot token from s.
+func Redact(s string) string {
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	secretsMu.RUnlock()
+
+	return botTokenPattern.ReplaceAllString(s, Redacted)
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			return slog.String(a.Key, Redact(v.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, Redact(v.String()))
+		}
+	}
+	return a
+}
+
+// NewCorrelationID returns a short random identifier for tying together the logs of one update.
+func NewCorrelationID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithCorrelationID returns a context whose logger tags every record with id and the given attributes.
+func WithCorrelationID(ctx context.Context, id string, args ...any) context.Context {
+	logger := FromContext(ctx).With(append([]any{CorrelationIDKey, id}, args...)...)
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
+
+// BotLogger routes the Telegram library's debug output through slog.
+type BotLogger struct{}
+
+func (BotLogger) Println(v ...interface{}) {
+	slog.Debug(strings.TrimSuffix(fmt.Sprintln(v...), "\n"), "component", "telegram")
+}
+
+func (BotLogger) Printf(format string, v ...interface{}) {
+	slog.Debug(fmt.Sprintf(format, v...), "component", "telegram")
+}
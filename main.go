@@ -1,20 +1,45 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"runtime/debug"
 	"strings"
+	"sync"
+	"syscall"
 
+	"duarteocarmo/ambrosio/logging"
+	"duarteocarmo/ambrosio/metrics"
+	"duarteocarmo/ambrosio/model/fakellm"
 	"duarteocarmo/ambrosio/modes"
+	"duarteocarmo/ambrosio/notes"
+	"duarteocarmo/ambrosio/rag"
+	"duarteocarmo/ambrosio/reminders"
+	"duarteocarmo/ambrosio/telegram"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 const (
-	PhotoMode     = "photo"
-	AssistantMode = "assistant"
-	Timeout       = 60
+	PhotoMode        = "photo"
+	AssistantMode    = "assistant"
+	RemindCommand    = "remind"
+	RemindersCommand = "reminders"
+	TimezoneCommand  = "timezone"
+	NoteCommand      = "note"
+	NotesCommand     = "notes"
+	TodoCommand      = "todo"
+	SummarizeCommand = "summarize"
+	HelpCommand      = "help"
+	Timeout          = 60
+	PollingMode      = "polling"
+	WebhookMode      = "webhook"
+	DefaultDataDir   = "data"
 )
 
 func createBot() (*tgbotapi.BotAPI, error) {
@@ -26,16 +51,19 @@ func createBot() (*tgbotapi.BotAPI, error) {
 	case "DEV":
 		t = os.Getenv("TELEGRAM_APITOKEN_DEV")
 		d = true
-		log.Println("Running in DEV mode")
+		slog.Info("Running in DEV mode")
 	case "PROD":
 		t = os.Getenv("TELEGRAM_APITOKEN_PROD")
 		d = false
-		log.Println("Running in PROD mode")
+		slog.Info("Running in PROD mode")
 	default:
-		log.Println("No mode specified. Exiting...")
+		slog.Error("No mode specified. Exiting...")
 		return nil, fmt.Errorf("no mode specified")
 	}
 
+	logging.AddSecret(t)
+	tgbotapi.SetLogger(logging.BotLogger{})
+
 	bot, err := tgbotapi.NewBotAPI(t)
 
 	if err != nil {
@@ -44,72 +72,349 @@ func createBot() (*tgbotapi.BotAPI, error) {
 
 	bot.Debug = d
 
-	log.Printf("Authorized on account %s", bot.Self.UserName)
-	log.Printf("Bot is running")
+	slog.Info("Authorized on account", "username", bot.Self.UserName)
+	slog.Info("Bot is running")
 
 	return bot, nil
 
 }
 
 func main() {
+	fakeLLM := flag.Bool("fake-llm", false, "answer model requests with a local fake instead of the Together API")
+	flag.Parse()
+
+	if flag.Arg(0) == "healthcheck" {
+		os.Exit(healthcheck())
+	}
+
+	if err := logging.Setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, secret := range []string{"TOGETHER_API_KEY", "AWS_SECRET_ACCESS_KEY", "WEBHOOK_SECRET", "STT_API_KEY", "TTS_API_KEY"} {
+		logging.AddSecret(os.Getenv(secret))
+	}
+
 	bot, err := createBot()
 	if err != nil {
-		log.Panicf("Error creating bot: %v", err)
+		slog.Error("Error creating bot", "error", err)
+		os.Exit(1)
 	}
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = Timeout
-	updates := bot.GetUpdatesChan(u)
-	authorizedUser := os.Getenv("TELEGRAM_USERNAME")
-
-	availableModes := []string{PhotoMode, AssistantMode}
-	helpMsg := "I don't know that command. Available commands are: \n/" + strings.Join(availableModes, "\n/")
+	if err := registerCommands(bot); err != nil {
+		// the bot works without the menu, commands just aren't suggested
+		slog.Warn("Could not register the command menu", "error", err)
+	}
 
-	for update := range updates {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-		if update.Message == nil {
-			continue
+	if *fakeLLM {
+		if err := startFakeLLM(ctx); err != nil {
+			slog.Error("Error starting fake LLM", "error", err)
+			os.Exit(1)
 		}
+	}
 
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+	go func() {
+		<-ctx.Done()
+		// restore default signal handling so a second signal forces the exit
+		stop()
+		slog.Info("Shutting down, waiting for in-flight flows to finish")
+	}()
 
-		if update.SentFrom().UserName != authorizedUser {
-			msg.Text = "Sorry, you are not authorized to use this bot"
-			bot.Send(msg)
-			log.Printf("Detected unauthorized user %s", update.SentFrom().UserName)
-			continue
+	// background work main waits for before exiting
+	var background sync.WaitGroup
+
+	srv := newServer(bot)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		if err := srv.Run(ctx); err != nil {
+			slog.Error("HTTP server stopped", "error", err)
 		}
+	}()
+
+	updates, err := receiveUpdates(ctx, bot, srv)
+	if err != nil {
+		slog.Error("Error receiving updates", "error", err)
+		os.Exit(1)
+	}
+
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = DefaultDataDir
+	}
+	stores, err := openStores(dataDir)
+	if err != nil {
+		slog.Error("Error loading data", "error", err)
+		os.Exit(1)
+	}
+	allowlist, err := parseGroups(os.Getenv("TELEGRAM_GROUPS"))
+	if err != nil {
+		slog.Error("Error reading group allowlist", "error", err)
+		os.Exit(1)
+	}
+
+	messages := make(chan tgbotapi.Update, bot.Buffer)
+	messenger := telegram.NewBot(bot, messages)
+	inline := modes.NewInline(messenger, os.Getenv("PHOTOS_URL"))
+	groups := modes.NewGroupChats(messenger, bot.Self)
+	// flows already running are allowed to finish after a shutdown signal
+	baseCtx := context.WithoutCancel(ctx)
+
+	r := &router{bot: messenger, stores: stores, inline: inline, groups: groups, allowlist: allowlist}
+	go r.run(baseCtx, updates, messages)
+
+	scheduler := &reminders.Scheduler{Store: stores.Reminders, Send: modes.SendReminder(messenger)}
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		scheduler.Run(logging.WithCorrelationID(ctx, "scheduler"))
+	}()
+
+	for update := range messenger.Updates() {
+		updateCtx := logging.WithCorrelationID(baseCtx, logging.NewCorrelationID(), "update_id", update.UpdateID)
+		handleUpdate(updateCtx, update, messenger, stores, allowlist)
+	}
+
+	slog.Info("Stopped receiving updates, waiting for the server, scheduler and open answers")
+	r.wait()
+	background.Wait()
+	slog.Info("Exiting")
+}
+
+// Stores holds the data kept in DATA_DIR.
+type Stores struct {
+	Reminders *reminders.Store
+	Notes     *notes.Store
+	Index     *rag.Index
+}
+
+func openStores(dir string) (*Stores, error) {
+	reminderStore, err := reminders.NewStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	noteStore, err := notes.NewStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	index, err := rag.OpenIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Stores{Reminders: reminderStore, Notes: noteStore, Index: index}, nil
+}
+
+// startFakeLLM serves the fakellm endpoints locally and points the model client at them.
+func startFakeLLM(ctx context.Context) error {
+	baseURL, _, err := fakellm.Serve(ctx)
+	if err != nil {
+		return err
+	}
+
+	os.Setenv("TOGETHER_BASE_URL", baseURL)
+	if os.Getenv("TOGETHER_API_KEY") == "" {
+		os.Setenv("TOGETHER_API_KEY", "fake")
+	}
+
+	slog.Warn("Using fake LLM, model answers are canned", "url", baseURL)
+	return nil
+}
+
+func handleUpdate(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, stores *Stores, allowlist groupAllowlist) {
+	if update.CallbackQuery != nil {
+		handleButton(ctx, update, bot, stores, allowlist)
+		return
+	}
+	if update.Message == nil {
+		return
+	}
+
+	logger := logging.FromContext(ctx)
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+	private := update.Message.Chat.IsPrivate()
+	if !private {
+		msg.ReplyToMessageID = update.Message.MessageID
+	}
 
-		if !update.Message.IsCommand() {
-			msg.Text = helpMsg
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("Recovered from panic while handling update", "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+			msg.Text = logging.Redact(fmt.Sprintf("Something went wrong: %v", r))
 			bot.Send(msg)
-			continue
 		}
+	}()
 
-		switch update.Message.Command() {
-		case PhotoMode, strings.ToLower(PhotoMode)[0:1]:
-			err := modes.PhotoMode(update, updates, bot)
-			if err != nil {
-				log.Printf("Error in photo mode: %v", err)
-				msg.Text = fmt.Sprintf("Error in photo mode: %v", err)
-				bot.Send(msg)
-			}
-			continue
-		case AssistantMode, strings.ToLower(AssistantMode)[0:1]:
-			err := modes.AssistantMode(update, updates, bot)
-			if err != nil {
-				log.Printf("Error in assistant mode: %v", err)
-				msg.Text = fmt.Sprintf("Error in assistant mode: %v", err)
-				bot.Send(msg)
+	role, ok := userRole(update.Message.Chat, update.SentFrom(), allowlist)
+	if !ok {
+		msg.Text = "Sorry, you are not authorized to use this bot"
+		bot.Send(msg)
+		logger.Warn("Detected unauthorized user", "username", update.SentFrom().String())
+		return
+	}
+
+	if !update.Message.IsCommand() {
+		msg.Text = "I don't know that command. " + registry.Help(role, private)
+		bot.Send(msg)
+		return
+	}
+
+	command, ok := registry.Lookup(update.Message.Command())
+	if !ok {
+		metrics.Commands.Inc("unknown")
+		msg.Text = fmt.Sprintf("I don't know /%s. %s", update.Message.Command(), registry.Help(role, private))
+		bot.Send(msg)
+		return
+	}
+
+	metrics.Commands.Inc(command.Name)
+	args := strings.Fields(update.Message.CommandArguments())
+
+	switch {
+	case command.Role > role:
+		msg.Text = fmt.Sprintf("Sorry, only the owner of this bot can use /%s.", command.Name)
+
+	case command.Name == HelpCommand:
+		msg.Text = registry.Help(role, private)
+		if len(args) > 0 {
+			name := strings.TrimPrefix(args[0], "/")
+			if c, ok := registry.Lookup(name); ok && c.Role <= role {
+				msg.Text = c.Help()
+			} else {
+				msg.Text = fmt.Sprintf("I don't know /%s. %s", name, msg.Text)
 			}
-			continue
-		default:
-			msg.Text = helpMsg
+		}
 
+	case command.Private && !private:
+		msg.Text = fmt.Sprintf("/%s only works in our private chat. Mention me here to chat.", command.Name)
+
+	case command.RequiresSubcommand && (len(args) == 0 || !command.hasSubcommand(args[0])):
+		msg.Text = command.Help()
+		msg.ReplyMarkup = command.Keyboard()
+
+	default:
+		if err := command.Run(ctx, update, bot, stores); err != nil {
+			reportModeError(ctx, bot, msg, command.Name, err)
 		}
+		return
+	}
+
+	if _, err := bot.Send(msg); err != nil {
+		logger.Error("Error sending message", "error", err)
+	}
+}
+
+// userRole returns the role of user in chat, or false if they may not use the bot there.
+func userRole(chat *tgbotapi.Chat, user *tgbotapi.User, allowlist groupAllowlist) (Role, bool) {
+	owner := os.Getenv("TELEGRAM_USERNAME")
+	switch {
+	case user != nil && user.UserName == owner && (chat.IsPrivate() || allowlist.has(chat.ID)):
+		return RoleOwner, true
+	case !chat.IsPrivate() && allowlist.allows(chat.ID, user, owner):
+		return RoleMember, true
+	default:
+		return RoleMember, false
+	}
+}
+
+// handleInlineQuery answers inline queries of the authorized user, and nothing to anyone else.
+func handleInlineQuery(ctx context.Context, query *tgbotapi.InlineQuery, inline *modes.Inline) {
+	logger := logging.FromContext(ctx)
 
-		if _, err := bot.Send(msg); err != nil {
-			log.Panicf("Error sending message: %v", err)
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("Recovered from panic while answering inline query", "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
 		}
+	}()
+
+	if query.From == nil || query.From.UserName != os.Getenv("TELEGRAM_USERNAME") {
+		logger.Warn("Detected unauthorized user", "username", query.From.String())
+		inline.Bot.Request(tgbotapi.InlineConfig{InlineQueryID: query.ID, IsPersonal: true, Results: []interface{}{}})
+		return
 	}
+
+	inline.Handle(ctx, query)
+}
+
+// handleGroupMessage answers a group message mentioning the bot or replying to it.
+func handleGroupMessage(ctx context.Context, message *tgbotapi.Message, groups *modes.GroupChats, allowlist groupAllowlist) {
+	logger := logging.FromContext(ctx).With("chat_id", message.Chat.ID)
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("Recovered from panic while handling group message", "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+		}
+	}()
+
+	if !allowlist.allows(message.Chat.ID, message.From, os.Getenv("TELEGRAM_USERNAME")) {
+		logger.Warn("Detected unauthorized user", "username", message.From.String())
+		reply := tgbotapi.NewMessage(message.Chat.ID, "Sorry, you are not authorized to use this bot")
+		reply.ReplyToMessageID = message.MessageID
+		groups.Bot.Send(reply)
+		return
+	}
+
+	if err := groups.Handle(ctx, message); err != nil {
+		logger.Error("Error in group chat", "error", err)
+	}
+}
+
+// handleButton answers inline buttons pressed outside of a running flow. Reminder
+// buttons outlive their flow and command buttons run their command; any other button
+// belongs to a flow that is over.
+func handleButton(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, stores *Stores, allowlist groupAllowlist) {
+	query := update.CallbackQuery
+	logger := logging.FromContext(ctx)
+
+	if query.From == nil || query.From.UserName != os.Getenv("TELEGRAM_USERNAME") {
+		logger.Warn("Detected unauthorized user", "username", query.From.String())
+		bot.Request(tgbotapi.NewCallback(query.ID, "Sorry, you are not authorized to use this bot"))
+		return
+	}
+
+	data, ok := modes.ParseButtonData(query.Data)
+	switch {
+	case ok && data.Kind == modes.ButtonReminder:
+		if err := modes.ReminderButton(ctx, query, bot, stores.Reminders); err != nil {
+			logger.Error("Error handling button", "error", err)
+		}
+
+	case ok && data.Kind == modes.ButtonCommand && query.Message != nil:
+		bot.Request(tgbotapi.NewCallback(query.ID, ""))
+		modes.RemoveKeyboard(bot, query)
+		handleUpdate(ctx, commandUpdate(update.UpdateID, query, data.Arg(0), data.Arg(1)), bot, stores, allowlist)
+
+	default:
+		bot.Request(tgbotapi.NewCallback(query.ID, modes.ExpiredButton))
+	}
+}
+
+// commandUpdate is the update typing /command subcommand in the chat of a pressed button.
+func commandUpdate(updateID int, query *tgbotapi.CallbackQuery, command, subcommand string) tgbotapi.Update {
+	text := "/" + command
+	return tgbotapi.Update{UpdateID: updateID, Message: &tgbotapi.Message{
+		MessageID: query.Message.MessageID,
+		From:      query.From,
+		Chat:      query.Message.Chat,
+		Date:      query.Message.Date,
+		Text:      text + " " + subcommand,
+		Entities:  []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(text)}},
+	}}
+}
+
+func reportModeError(ctx context.Context, bot telegram.Messenger, msg tgbotapi.MessageConfig, mode string, err error) {
+	logger := logging.FromContext(ctx).With("mode", mode)
+
+	if errors.Is(err, modes.ErrShuttingDown) {
+		logger.Info("Interrupted flow", "error", err)
+		msg.Text = "Ambrosio is restarting, please start again in a moment."
+		bot.Send(msg)
+		return
+	}
+
+	logger.Error("Error in mode", "error", err)
+	msg.Text = logging.Redact(fmt.Sprintf("Error in %s mode: %v", mode, err))
+	bot.Send(msg)
 }
@@ -0,0 +1,202 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	Updates = NewCounter("ambrosio_updates_total",
+		"Telegram updates received, by update type.", "type")
+	Commands = NewCounter("ambrosio_commands_total",
+		"Bot commands handled, by command.", "command")
+	LLMRequests = NewCounter("ambrosio_llm_requests_total",
+		"Requests sent to the LLM provider, by endpoint and result.", "endpoint", "result")
+	LLMErrors = NewCounter("ambrosio_llm_errors_total",
+		"Failed LLM requests, by endpoint and HTTP status (0 for transport errors).", "endpoint", "status")
+	LLMLatency = NewHistogram("ambrosio_llm_request_duration_seconds",
+		"Latency of LLM requests including retries, by endpoint.",
+		[]float64{0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}, "endpoint")
+	PhotoUploads = NewCounter("ambrosio_photo_uploads_total",
+		"Photos uploaded to the bucket, by result.", "result")
+	DeployHooks = NewCounter("ambrosio_deploy_hooks_total",
+		"Website deploy hook calls, by result.", "result")
+	Reminders = NewCounter("ambrosio_reminders_sent_total",
+		"Reminders sent, by result.", "result")
+)
+
+// Result is the label value used for outcomes of an operation.
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []metric
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, m)
+}
+
+// Counter is a monotonically increasing value partitioned by label values.
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, values: map[string]float64{}}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := labelKey(c.labels, labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += v
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %g\n", c.name, key, c.values[key])
+	}
+}
+
+// Histogram counts observations into cumulative buckets partitioned by label values.
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := labelKey(h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labelValues: append([]string{}, labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Since observes the seconds elapsed since start.
+func (h *Histogram) Since(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		names := append(append([]string{}, h.labels...), "le")
+		values := append(append([]string{}, s.labelValues...), "")
+		for i, upper := range h.buckets {
+			values[len(values)-1] = fmt.Sprintf("%g", upper)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelKey(names, values), s.counts[i])
+		}
+		values[len(values)-1] = "+Inf"
+		inf := labelKey(names, values)
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, inf, s.count)
+		fmt.Fprintf(w, "%s_sum%s %g\n", h.name, key, s.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, s.count)
+	}
+}
+
+// WritePrometheus writes every registered metric in the Prometheus text exposition format.
+func WritePrometheus(w io.Writer) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for _, m := range registry {
+		m.write(w)
+	}
+}
+
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WritePrometheus(w)
+	})
+}
+
+func labelKey(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = fmt.Sprintf("%s=%q", name, value)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
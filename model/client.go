@@ -0,0 +1,260 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"duarteocarmo/ambrosio/logging"
+	"duarteocarmo/ambrosio/metrics"
+)
+
+const (
+	DefaultBaseURL     = "https://api.together.xyz"
+	DefaultMaxRetries  = 3
+	DefaultBaseBackoff = 1 * time.Second
+	MaxBackoff         = 30 * time.Second
+	RequestTimeout     = 120 * time.Second
+)
+
+// APIError is a non-2xx response returned by the Together API.
+type APIError struct {
+	StatusCode int
+	Message    string
+	Type       string
+	Code       string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.Type != "" {
+		return fmt.Sprintf("together api error %d (%s): %s", e.StatusCode, e.Type, msg)
+	}
+	return fmt.Sprintf("together api error %d: %s", e.StatusCode, msg)
+}
+
+// Retryable reports whether the request that produced the error may succeed if sent again.
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// UserMessage turns any error returned by the Client into text suitable for the chat.
+func UserMessage(err error) string {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return fmt.Sprintf("Something went wrong talking to the model: %v", err)
+	}
+
+	switch {
+	case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+		return "The model provider rejected our API key. Check TOGETHER_API_KEY."
+	case apiErr.StatusCode == http.StatusTooManyRequests:
+		return "The model provider is rate limiting us. Please try again in a bit."
+	case apiErr.StatusCode >= 500:
+		return "The model provider is having problems right now. Please try again later."
+	case apiErr.Message != "":
+		return "The model provider refused the request: " + apiErr.Message
+	default:
+		return fmt.Sprintf("The model provider refused the request (%d).", apiErr.StatusCode)
+	}
+}
+
+// Client sends JSON requests to the Together API, retrying rate limits and server errors.
+type Client struct {
+	BaseURL     string
+	APIKey      string
+	HTTPClient  *http.Client
+	MaxRetries  int
+	BaseBackoff time.Duration
+}
+
+// NewClient configures a Client from TOGETHER_API_KEY and, to point it at another
+// server such as the fake in fakellm, TOGETHER_BASE_URL.
+func NewClient() (*Client, error) {
+	apiKey := os.Getenv("TOGETHER_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("TOGETHER_API_KEY environment variable not set")
+	}
+
+	baseURL := os.Getenv("TOGETHER_BASE_URL")
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+
+	return &Client{
+		BaseURL:     strings.TrimSuffix(baseURL, "/"),
+		APIKey:      apiKey,
+		HTTPClient:  &http.Client{Timeout: RequestTimeout},
+		MaxRetries:  DefaultMaxRetries,
+		BaseBackoff: DefaultBaseBackoff,
+	}, nil
+}
+
+// Post marshals payload, sends it to endpoint and returns the body of a successful response.
+// Endpoints starting with / are relative to BaseURL.
+func (c *Client) Post(ctx context.Context, endpoint string, payload interface{}) (body []byte, err error) {
+	url := c.url(endpoint)
+	label := endpointLabel(url)
+	start := time.Now()
+	defer func() {
+		metrics.LLMLatency.Since(start, label)
+		metrics.LLMRequests.Inc(label, metrics.Result(err))
+		if err != nil {
+			status := 0
+			var apiErr *APIError
+			if errors.As(err, &apiErr) {
+				status = apiErr.StatusCode
+			}
+			metrics.LLMErrors.Inc(label, strconv.Itoa(status))
+		}
+	}()
+
+	bytesPayload, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		body, err := c.do(ctx, url, bytesPayload)
+		if err == nil {
+			return body, nil
+		}
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || !apiErr.Retryable() || attempt >= c.MaxRetries {
+			return nil, err
+		}
+
+		wait := c.backoff(attempt, apiErr.RetryAfter)
+		logging.FromContext(ctx).Warn("Together request failed, retrying", "error", err, "attempt", attempt+1, "wait", wait)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Client) url(endpoint string) string {
+	if strings.HasPrefix(endpoint, "/") {
+		return c.BaseURL + endpoint
+	}
+	return endpoint
+}
+
+func (c *Client) do(ctx context.Context, url string, payload []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, decodeAPIError(resp, body)
+	}
+
+	return body, nil
+}
+
+func (c *Client) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		if retryAfter > MaxBackoff {
+			return MaxBackoff
+		}
+		return retryAfter
+	}
+
+	wait := c.BaseBackoff << attempt
+	if wait <= 0 || wait > MaxBackoff {
+		return MaxBackoff
+	}
+	return wait
+}
+
+// decodeAPIError understands both {"error": {"message": ...}} and {"error": "..."} payloads.
+func decodeAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	var payload struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		apiErr.Message = strings.TrimSpace(string(body))
+		return apiErr
+	}
+
+	var detail struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Code    interface{} `json:"code"`
+	}
+	var text string
+
+	switch {
+	case json.Unmarshal(payload.Error, &detail) == nil:
+		apiErr.Message = detail.Message
+		apiErr.Type = detail.Type
+		if detail.Code != nil {
+			apiErr.Code = fmt.Sprint(detail.Code)
+		}
+	case json.Unmarshal(payload.Error, &text) == nil:
+		apiErr.Message = text
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = payload.Message
+	}
+
+	return apiErr
+}
+
+func endpointLabel(rawURL string) string {
+	u, err := neturl.Parse(rawURL)
+	if err != nil || u.Path == "" {
+		return rawURL
+	}
+	return u.Path
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -0,0 +1,244 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"duarteocarmo/ambrosio/model/fakellm"
+)
+
+func newTestClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:     baseURL,
+		APIKey:      "test",
+		HTTPClient:  &http.Client{Timeout: 5 * time.Second},
+		MaxRetries:  DefaultMaxRetries,
+		BaseBackoff: time.Millisecond,
+	}
+}
+
+func chatPayload(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"model":    "test",
+		"messages": []map[string]string{{"role": "user", "content": text}},
+	}
+}
+
+func TestClientRetriesRateLimitsAfterRetryAfter(t *testing.T) {
+	srv, llm := fakellm.NewServer()
+	defer srv.Close()
+
+	client := newTestClient(srv.URL)
+	client.MaxRetries = 1
+
+	start := time.Now()
+	_, err := client.Post(context.Background(), "/v1/chat/completions", chatPayload("fake:error 429"))
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("err = %v, want a 429 APIError", err)
+	}
+	if apiErr.RetryAfter != time.Second {
+		t.Errorf("RetryAfter = %s, want 1s from the header", apiErr.RetryAfter)
+	}
+	// the one retry waits for Retry-After rather than the millisecond backoff
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, before Retry-After", elapsed)
+	}
+	if n := len(llm.Requests()); n != 2 {
+		t.Errorf("sent %d requests, want 2", n)
+	}
+	if msg := UserMessage(err); !strings.Contains(msg, "rate limiting") {
+		t.Errorf("UserMessage = %q", msg)
+	}
+}
+
+func TestClientRecoversFromServerErrors(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	body, err := newTestClient(srv.URL).Post(context.Background(), "/v1/chat/completions", chatPayload("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"ok":true}` || calls != 3 {
+		t.Errorf("body = %s after %d calls, want the third answer", body, calls)
+	}
+}
+
+func TestClientErrorsForTheChat(t *testing.T) {
+	srv, llm := fakellm.NewServer()
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		apiKey   string
+		text     string
+		status   int
+		requests int
+		message  string
+	}{
+		// the fake records requests once they are authorized
+		{"unauthorized", "", "hi", http.StatusUnauthorized, 0, "rejected our API key"},
+		{"server error", "test", "fake:error 503", http.StatusServiceUnavailable, DefaultMaxRetries + 1, "having problems"},
+		{"bad request", "test", "fake:error 400", http.StatusBadRequest, 1, "refused the request: fake error 400 requested"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(llm.Requests())
+			client := newTestClient(srv.URL)
+			client.APIKey = tt.apiKey
+
+			_, err := client.Post(context.Background(), "/v1/chat/completions", chatPayload(tt.text))
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Fatalf("err = %v, want status %d", err, tt.status)
+			}
+			if n := len(llm.Requests()) - before; n != tt.requests {
+				t.Errorf("sent %d requests, want %d", n, tt.requests)
+			}
+			if msg := UserMessage(err); !strings.Contains(msg, tt.message) {
+				t.Errorf("UserMessage = %q, want it to contain %q", msg, tt.message)
+			}
+		})
+	}
+}
+
+func TestDecodeAPIError(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want APIError
+	}{
+		{"object", `{"error": {"message": "bad model", "type": "invalid_request_error", "code": 42}}`,
+			APIError{Message: "bad model", Type: "invalid_request_error", Code: "42"}},
+		{"string", `{"error": "bad model"}`, APIError{Message: "bad model"}},
+		{"top-level message", `{"message": "bad model"}`, APIError{Message: "bad model"}},
+		{"not JSON", "  upstream timed out\n", APIError{Message: "upstream timed out"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: http.StatusBadRequest, Header: http.Header{"Retry-After": {"7"}}}
+			got := decodeAPIError(resp, []byte(tt.body))
+
+			tt.want.StatusCode = http.StatusBadRequest
+			tt.want.RetryAfter = 7 * time.Second
+			if *got != tt.want {
+				t.Errorf("decodeAPIError = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFakeImages(t *testing.T) {
+	srv, _ := fakellm.NewServer()
+	defer srv.Close()
+
+	body, err := newTestClient(srv.URL).Post(context.Background(), "/inference", map[string]interface{}{
+		"model": "test", "prompt": "a lighthouse", "n": 2, "seed": 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp struct {
+		Output struct {
+			Choices []struct {
+				Image string `json:"image_base64"`
+			} `json:"choices"`
+		} `json:"output"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Output.Choices) != 2 {
+		t.Fatalf("got %d images, want 2", len(resp.Output.Choices))
+	}
+	for _, choice := range resp.Output.Choices {
+		data, err := base64.StdEncoding.DecodeString(choice.Image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := img.Bounds().Size(); size.X != fakellm.ImageSize || size.Y != fakellm.ImageSize {
+			t.Errorf("image is %v, want %dx%d", size, fakellm.ImageSize, fakellm.ImageSize)
+		}
+	}
+	if resp.Output.Choices[0].Image == resp.Output.Choices[1].Image {
+		t.Error("both images are the same")
+	}
+}
+
+func TestFakeRejectsEmptyMessages(t *testing.T) {
+	srv, _ := fakellm.NewServer()
+	defer srv.Close()
+
+	_, err := newTestClient(srv.URL).Post(context.Background(), "/v1/chat/completions", map[string]interface{}{
+		"model": "test", "messages": []interface{}{},
+	})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("err = %v, want a 400 APIError", err)
+	}
+}
+
+func TestFakeKeepsRepliesAfterToolResults(t *testing.T) {
+	srv, llm := fakellm.NewServer()
+	defer srv.Close()
+	llm.Reply("scripted")
+
+	client := newTestClient(srv.URL)
+	reply := func(messages ...map[string]string) string {
+		t.Helper()
+		body, err := client.Post(context.Background(), "/v1/chat/completions", map[string]interface{}{
+			"model": "test", "messages": messages,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp struct {
+			Choices []struct {
+				Message struct {
+					Content string `json:"content"`
+				} `json:"message"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil || len(resp.Choices) == 0 {
+			t.Fatalf("decoding %s: %v", body, err)
+		}
+		return resp.Choices[0].Message.Content
+	}
+
+	question := map[string]string{"role": "user", "content": "what time is it?"}
+	result := map[string]string{"role": "tool", "name": "clock", "tool_call_id": "call_1", "content": "noon"}
+	if got := reply(question, result); got != "Tool clock returned: noon" {
+		t.Errorf("tool turn answered %q, want the tool result", got)
+	}
+	if got := reply(question); got != "scripted" {
+		t.Errorf("next turn answered %q, want the queued reply", got)
+	}
+}
@@ -0,0 +1,406 @@
+// Package fakellm is an offline stand-in for the Together API. It answers chat
+// completions (plain and streamed), image generations and scripted errors, for
+// tests and for running the bot with --fake-llm.
+//
+// A user message or image prompt containing "fake:error <status>" makes the
+// server answer with that HTTP status and a Together-style error payload.
+//
+// Embeddings are bag-of-words vectors, so texts sharing words are similar.
+//
+// A user message containing "fake:tool <name> <json arguments>" makes the server call
+// that tool, when the request offers it, and answer with the tool result afterwards.
+package fakellm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	ImageSize     = 64
+	EmbeddingSize = 64
+)
+
+var (
+	errorTrigger = regexp.MustCompile(`fake:error (\d{3})`)
+	toolTrigger  = regexp.MustCompile(`fake:tool (\w+) ?(\{.*\})?`)
+)
+
+type message struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+	Name    string          `json:"name"`
+}
+
+type tool struct {
+	Function struct {
+		Name string `json:"name"`
+	} `json:"function"`
+}
+
+type chatRequest struct {
+	Model    string    `json:"model"`
+	Stream   bool      `json:"stream"`
+	Messages []message `json:"messages"`
+	Tools    []tool    `json:"tools"`
+}
+
+type imageRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+	N      int    `json:"n"`
+	Seed   int    `json:"seed"`
+}
+
+// Request is a call received by the fake.
+type Request struct {
+	Path string
+	Body []byte
+}
+
+// Handler implements the fake Together endpoints.
+type Handler struct {
+	mu       sync.Mutex
+	replies  []string
+	requests []Request
+	mux      *http.ServeMux
+}
+
+func NewHandler() *Handler {
+	h := &Handler{mux: http.NewServeMux()}
+	h.mux.HandleFunc("/v1/chat/completions", h.chat)
+	h.mux.HandleFunc("/inference", h.inference)
+	h.mux.HandleFunc("/v1/embeddings", h.embeddings)
+	return h
+}
+
+// NewServer starts an httptest server backed by a new Handler.
+func NewServer() (*httptest.Server, *Handler) {
+	h := NewHandler()
+	return httptest.NewServer(h), h
+}
+
+// Serve listens on a random local port until ctx is done and returns the base URL.
+func Serve(ctx context.Context) (string, *Handler, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+
+	h := NewHandler()
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(listener)
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	return "http://" + listener.Addr().String(), h, nil
+}
+
+// Reply queues canned chat answers, used in order before falling back to echoing the user.
+func (h *Handler) Reply(replies ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.replies = append(h.replies, replies...)
+}
+
+// Requests returns every request received so far.
+func (h *Handler) Requests() []Request {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Request{}, h.requests...)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") || r.Header.Get("Authorization") == "Bearer " {
+		writeError(w, http.StatusUnauthorized, "Invalid API key provided")
+		return
+	}
+
+	var body bytes.Buffer
+	body.ReadFrom(r.Body)
+	r.Body.Close()
+
+	h.mu.Lock()
+	h.requests = append(h.requests, Request{Path: r.URL.Path, Body: body.Bytes()})
+	h.mu.Unlock()
+
+	r.Body = io.NopCloser(bytes.NewReader(body.Bytes()))
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) chat(w http.ResponseWriter, r *http.Request) {
+	var req chatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeError(w, http.StatusBadRequest, "messages must not be empty")
+		return
+	}
+
+	last := lastUserText(req.Messages)
+	if writeTriggeredError(w, last) {
+		return
+	}
+
+	if call := toolCall(req); call != nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":     "fake-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+			"object": "chat.completion",
+			"model":  req.Model,
+			"choices": []map[string]interface{}{{
+				"index":         0,
+				"message":       map[string]interface{}{"role": "assistant", "content": "", "tool_calls": []interface{}{call}},
+				"finish_reason": "tool_calls",
+			}},
+		})
+		return
+	}
+
+	// turns ending with a tool result are answered with it, keeping queued replies for the next
+	var reply string
+	if result := req.Messages[len(req.Messages)-1]; result.Role == "tool" {
+		var text string
+		json.Unmarshal(result.Content, &text)
+		reply = fmt.Sprintf("Tool %s returned: %s", result.Name, text)
+	} else {
+		reply = h.nextReply(last)
+	}
+	slog.Debug("Fake LLM chat completion", "model", req.Model, "stream", req.Stream)
+
+	if req.Stream {
+		streamReply(w, req.Model, reply)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     "fake-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		"object": "chat.completion",
+		"model":  req.Model,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"message":       map[string]string{"role": "assistant", "content": reply},
+			"finish_reason": "stop",
+		}},
+	})
+}
+
+func (h *Handler) inference(w http.ResponseWriter, r *http.Request) {
+	var req imageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if writeTriggeredError(w, req.Prompt) {
+		return
+	}
+	if req.N <= 0 {
+		req.N = 1
+	}
+
+	choices := []map[string]string{}
+	for i := 0; i < req.N; i++ {
+		img, err := placeholderImage(fmt.Sprintf("%s/%d/%d", req.Prompt, req.Seed, i))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		choices = append(choices, map[string]string{"image_base64": img})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "finished",
+		"output": map[string]interface{}{"choices": choices},
+	})
+}
+
+// toolCall returns the call requested with "fake:tool" in the last message, if it is
+// from the user and the tool is offered.
+func toolCall(req chatRequest) map[string]interface{} {
+	if len(req.Messages) == 0 || req.Messages[len(req.Messages)-1].Role != "user" {
+		return nil
+	}
+
+	match := toolTrigger.FindStringSubmatch(lastUserText(req.Messages))
+	if match == nil {
+		return nil
+	}
+
+	for _, t := range req.Tools {
+		if t.Function.Name != match[1] {
+			continue
+		}
+		args := match[2]
+		if args == "" {
+			args = "{}"
+		}
+		return map[string]interface{}{
+			"id":       "call_" + strconv.FormatInt(time.Now().UnixNano(), 36),
+			"type":     "function",
+			"function": map[string]string{"name": match[1], "arguments": args},
+		}
+	}
+	return nil
+}
+
+func (h *Handler) embeddings(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	data := []map[string]interface{}{}
+	for i, text := range req.Input {
+		if writeTriggeredError(w, text) {
+			return
+		}
+		data = append(data, map[string]interface{}{"object": "embedding", "index": i, "embedding": bagOfWords(text)})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "model": req.Model, "data": data})
+}
+
+// bagOfWords counts the words of text into EmbeddingSize hashed buckets.
+func bagOfWords(text string) []float32 {
+	vector := make([]float32, EmbeddingSize)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		vector[h.Sum32()%EmbeddingSize]++
+	}
+	return vector
+}
+
+func (h *Handler) nextReply(userText string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.replies) > 0 {
+		reply := h.replies[0]
+		h.replies = h.replies[1:]
+		return reply
+	}
+	return "You said: " + userText
+}
+
+func streamReply(w http.ResponseWriter, model, reply string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+
+	for _, word := range strings.SplitAfter(reply, " ") {
+		chunk, _ := json.Marshal(map[string]interface{}{
+			"object":  "chat.completion.chunk",
+			"model":   model,
+			"choices": []map[string]interface{}{{"index": 0, "delta": map[string]string{"content": word}}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", chunk)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// lastUserText returns the text of the last user message, whether content is a string or a list of parts.
+func lastUserText(messages []message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != "user" {
+			continue
+		}
+
+		var text string
+		if json.Unmarshal(messages[i].Content, &text) == nil {
+			return text
+		}
+
+		var parts []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}
+		json.Unmarshal(messages[i].Content, &parts)
+		var texts []string
+		for _, part := range parts {
+			if part.Type == "text" {
+				texts = append(texts, part.Text)
+			}
+		}
+		return strings.Join(texts, " ")
+	}
+	return ""
+}
+
+func writeTriggeredError(w http.ResponseWriter, text string) bool {
+	match := errorTrigger.FindStringSubmatch(text)
+	if match == nil {
+		return false
+	}
+
+	status, _ := strconv.Atoi(match[1])
+	if status == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", "1")
+	}
+	writeError(w, status, fmt.Sprintf("fake error %d requested", status))
+	return true
+}
+
+// placeholderImage returns a base64 PNG whose colour is derived from seed.
+func placeholderImage(seed string) (string, error) {
+	sum := sha1.Sum([]byte(seed))
+	img := image.NewRGBA(image.Rect(0, 0, ImageSize, ImageSize))
+	for y := 0; y < ImageSize; y++ {
+		for x := 0; x < ImageSize; x++ {
+			img.Set(x, y, color.RGBA{R: sum[0] + uint8(x), G: sum[1] + uint8(y), B: sum[2], A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{"message": message, "type": "fake_error", "code": nil},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,230 @@
+package modes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"duarteocarmo/ambrosio/logging"
+	"duarteocarmo/ambrosio/model"
+	"duarteocarmo/ambrosio/notes"
+	"duarteocarmo/ambrosio/rag"
+	"duarteocarmo/ambrosio/storage"
+	"duarteocarmo/ambrosio/telegram"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	AskMode = "ask"
+
+	// RetrievalResults is how many snippets are given to the model per question.
+	RetrievalResults = 4
+	// MinRelevance is the lowest cosine similarity of a snippet worth giving to the model.
+	MinRelevance = 0.3
+)
+
+// Knowledge is the personal data the ask mode retrieves from.
+type Knowledge struct {
+	Index *rag.Index
+	Notes *notes.Store
+	// Embedder defaults to rag.NewEmbedder when nil.
+	Embedder rag.Embedder
+}
+
+// knowledgeSources are the prefixes of the index entries ask mode answers from: notes,
+// to-dos, photos and the documents added in ask mode. Web pages are left out, as their
+// text is untrusted and may carry instructions for the model.
+var knowledgeSources = []string{notes.KindNote + " ", notes.KindTodo + " ", "photo ", "doc "}
+
+// listPhotos returns the gallery photos to index, replaceable in tests.
+var listPhotos = storage.ListPhotos
+
+func askFlow(ctx context.Context, bot telegram.Messenger, chatID int64, kb *Knowledge) error {
+	if kb == nil || kb.Index == nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "Ask mode is not available, the index could not be loaded."))
+		return nil
+	}
+
+	embedder := kb.Embedder
+	if embedder == nil {
+		var err error
+		if embedder, err = rag.NewEmbedder(); err != nil {
+			return err
+		}
+	}
+
+	bot.Send(tgbotapi.NewMessage(chatID, "Ask mode activated. Indexing your notes and photos..."))
+	bot.Send(tgbotapi.NewChatAction(chatID, "typing"))
+	if err := syncKnowledge(ctx, kb, embedder); err != nil {
+		logging.FromContext(ctx).Error("Error indexing knowledge", "error", err)
+		bot.Send(tgbotapi.NewMessage(chatID, model.UserMessage(err)))
+	}
+	f := newFlowSession(bot, chatID)
+	f.send(ctx, fmt.Sprintf("%d snippets indexed. Ask me anything about your notes, photos and documents, or send a PDF, Markdown or text file to add it.", kb.Index.Len()), f.actions(ResetCommand, ExitCommand))
+
+	systemPrompt, err := model.LoadPromptFromFile("system")
+	if err != nil {
+		return err
+	}
+	retrievalPrompt, err := model.LoadPromptFromFile("retrieval")
+	if err != nil {
+		return err
+	}
+	history := []Message{{Role: "system", Content: TextContent(systemPrompt)}}
+
+	for {
+		update, _, err := f.next()
+		if err != nil {
+			return err
+		}
+		text := strings.TrimSpace(update.Message.Text)
+
+		switch {
+		case strings.ToLower(text) == ExitCommand:
+			sendMessage(ctx, update, bot, "Ask mode deactivated.")
+			return nil
+
+		case strings.ToLower(text) == ResetCommand:
+			history = history[:1]
+			sendMessage(ctx, update, bot, "* Prompt reset *")
+
+		case update.Message.Document != nil:
+			bot.Send(tgbotapi.NewChatAction(chatID, "typing"))
+			added, err := ingestDocument(ctx, bot, kb.Index, embedder, update.Message.Document)
+			if err != nil {
+				logging.FromContext(ctx).Error("Error ingesting document", "error", err)
+				sendMessage(ctx, update, bot, fmt.Sprintf("Could not add that document: %v", err))
+				continue
+			}
+			sendMessage(ctx, update, bot, fmt.Sprintf("Added %s in %d snippets.", update.Message.Document.FileName, added))
+
+		case text != "":
+			bot.Send(tgbotapi.NewChatAction(chatID, "typing"))
+			results, err := kb.Index.Search(ctx, embedder, text, RetrievalResults, MinRelevance, knowledgeSources...)
+			if err != nil {
+				logging.FromContext(ctx).Error("Error searching knowledge", "error", err)
+				sendMessage(ctx, update, bot, model.UserMessage(err))
+				continue
+			}
+
+			question := Message{Role: "user", Content: TextContent(text)}
+			messages := append(append([]Message{}, history...), question)
+			if len(results) > 0 {
+				snippets := Message{Role: "system", Content: TextContent(retrievalPrompt + "\n\n" + formatSnippets(results))}
+				messages = append(append(append([]Message{}, history...), snippets), question)
+			}
+
+			answer, err := makeChatRequest(ctx, messages, nil)
+			if err != nil {
+				logging.FromContext(ctx).Error("Error in chat request", "error", err)
+				sendMessage(ctx, update, bot, model.UserMessage(err))
+				continue
+			}
+			history = append(history, question, answer)
+
+			msg := tgbotapi.NewMessage(chatID, answer.Content.String()+citedSources(answer.Content.String(), results))
+			msg.ParseMode = "Markdown"
+			if _, err := bot.Send(msg); err != nil {
+				// answers citing sources often have unbalanced markdown
+				msg.ParseMode = ""
+				bot.Send(msg)
+			}
+
+		default:
+			sendMessage(ctx, update, bot, "Please send a question, a PDF, Markdown or text file, or 'exit'.")
+		}
+	}
+}
+
+// syncKnowledge indexes the current notes and gallery photos, dropping deleted ones.
+// The notes of every chat are indexed, as only the owner saves notes, in private or in groups.
+// Photos are skipped with a warning when the bucket is unreachable.
+func syncKnowledge(ctx context.Context, kb *Knowledge, embedder rag.Embedder) error {
+	// pages read in chat mode were once indexed here too
+	if err := kb.Index.Sync(ctx, embedder, "page ", nil); err != nil {
+		return err
+	}
+
+	if kb.Notes != nil {
+		for _, kind := range []string{notes.KindNote, notes.KindTodo} {
+			var docs []rag.Document
+			for _, n := range kb.Notes.All(notes.Filter{Kind: kind, WithDone: true}) {
+				text := n.Text
+				if n.Done {
+					text += " (done)"
+				}
+				docs = append(docs, rag.Document{Source: fmt.Sprintf("%s %d", kind, n.ID), Text: text})
+			}
+			if err := kb.Index.Sync(ctx, embedder, kind+" ", docs); err != nil {
+				return err
+			}
+		}
+	}
+
+	photos, err := listPhotos(ctx, 0)
+	if err != nil {
+		logging.FromContext(ctx).Warn("Could not list photos to index", "error", err)
+		return nil
+	}
+
+	var docs []rag.Document
+	for _, p := range photos {
+		text := "Photo taken " + p.Date
+		if p.Caption != nil {
+			text += ". Caption: " + *p.Caption
+		}
+		if p.Location != nil {
+			text += ". Location: " + *p.Location
+		}
+		docs = append(docs, rag.Document{Source: "photo " + p.ID, Text: text})
+	}
+	return kb.Index.Sync(ctx, embedder, "photo ", docs)
+}
+
+// ingestDocument indexes an uploaded PDF, Markdown or text file in chunks, replacing an earlier upload
+// with the same name, and returns how many chunks were added.
+func ingestDocument(ctx context.Context, bot telegram.Messenger, index *rag.Index, embedder rag.Embedder, doc *tgbotapi.Document) (int, error) {
+	data, err := downloadFile(ctx, bot, doc.FileID)
+	if err != nil {
+		return 0, err
+	}
+
+	text, err := extractText(doc.FileName, doc.MimeType, data)
+	if err != nil {
+		return 0, err
+	}
+
+	var docs []rag.Document
+	for i, chunk := range rag.Chunk(text, rag.ChunkSize) {
+		docs = append(docs, rag.Document{Source: fmt.Sprintf("doc %s #%d", doc.FileName, i+1), Text: chunk})
+	}
+	if len(docs) == 0 {
+		return 0, fmt.Errorf("the file is empty")
+	}
+
+	return len(docs), index.Sync(ctx, embedder, fmt.Sprintf("doc %s #", doc.FileName), docs)
+}
+
+func formatSnippets(results []rag.Result) string {
+	var snippets []string
+	for i, r := range results {
+		snippets = append(snippets, fmt.Sprintf("[%d] (%s) %s", i+1, r.Source, r.Text))
+	}
+	return strings.Join(snippets, "\n\n")
+}
+
+// citedSources lists the sources of the snippets an answer cites.
+func citedSources(answer string, results []rag.Result) string {
+	var cited []string
+	for i, r := range results {
+		ref := fmt.Sprintf("[%d]", i+1)
+		if strings.Contains(answer, ref) {
+			cited = append(cited, ref+" "+r.Source)
+		}
+	}
+	if len(cited) == 0 {
+		return ""
+	}
+	return "\n\nSources: " + strings.Join(cited, ", ")
+}
@@ -2,41 +2,49 @@ package modes
 
 import (
 	"bytes"
+	"context"
+	"duarteocarmo/ambrosio/logging"
 	"duarteocarmo/ambrosio/model"
+	"duarteocarmo/ambrosio/telegram"
+	"duarteocarmo/ambrosio/web"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"image"
 	"image/png"
-	"io"
-	"log"
-	"net/http"
-	"os"
 	"strings"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 const (
-	TogetherEndpoint = "https://api.together.xyz/inference"
-	ChatEndpoint     = "https://api.together.xyz/v1/chat/completions"
+	TogetherEndpoint = "/inference"
+	ChatEndpoint     = "/v1/chat/completions"
 	ModelID          = "mistralai/Mixtral-8x7B-Instruct-v0.1"
+	VisionModelID    = "meta-llama/Llama-3.2-11B-Vision-Instruct-Turbo"
 	PhotoGenModelID  = "stabilityai/stable-diffusion-xl-base-1.0"
 	// PhotoGenModelID  = "stabilityai/stable-diffusion-2-1"
 	ChatMode         = "chat"
 	PhotoGenMode     = "photo"
 	ExitCommand      = "exit"
 	ResetCommand     = "reset"
+	VoiceCommand     = "voice"
+
+	DefaultImageQuestion = "What's in this picture?"
 )
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    Content    `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Name       string     `json:"name,omitempty"`
 }
 
 type ApiResponse struct {
 	Choices []struct {
-		Message `json:"message"`
+		Message      `json:"message"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
 }
 
@@ -58,38 +66,47 @@ type ApiRequest struct {
 	RepetitionPenalty float32   `json:"repetition_penalty"`
 	N                 int       `json:"n"`
 	Messages          []Message `json:"messages"`
+	Tools             []ApiTool `json:"tools,omitempty"`
 }
 
-func AssistantMode(currentUpdate tgbotapi.Update, updates tgbotapi.UpdatesChannel, bot *tgbotapi.BotAPI) error {
+func AssistantMode(ctx context.Context, currentUpdate tgbotapi.Update, bot telegram.Messenger, kb *Knowledge) error {
 
 	chatID := currentUpdate.Message.Chat.ID
-	supportedModes := []string{ChatMode, PhotoGenMode}
+	supportedModes := []string{ChatMode, PhotoGenMode, AskMode}
 	noActionError := fmt.Errorf("No action specified, provide one of the following: %s", strings.Join(supportedModes, ", "))
 
 	if currentUpdate.Message == nil || currentUpdate.Message.Text == "" {
 		return noActionError
 	}
 
-	textParts := strings.Split(currentUpdate.Message.Text, " ")
+	textParts := strings.Fields(currentUpdate.Message.Text)
 	if len(textParts) <= 1 {
 		return noActionError
 	}
 
-	selectedAction := textParts[1]
-	log.Printf("Selected action: %s", selectedAction)
+	// the registry accepts subcommands in any case
+	selectedAction := strings.ToLower(textParts[1])
+	logging.FromContext(ctx).Info("Selected action", "action", selectedAction)
 
 	switch selectedAction {
 	case ChatMode:
-		err := chatFlow(updates, bot, chatID)
+		err := chatFlow(ctx, bot, chatID, kb)
 		if err != nil {
-			return fmt.Errorf("error creating photo: %v", err)
+			return fmt.Errorf("error in chat: %w", err)
 		}
 		return nil
 
 	case PhotoGenMode:
-		err := photogenFlow(updates, bot, chatID)
+		err := photogenFlow(ctx, bot, chatID)
+		if err != nil {
+			return fmt.Errorf("error generating images: %w", err)
+		}
+		return nil
+
+	case AskMode:
+		err := askFlow(ctx, bot, chatID, kb)
 		if err != nil {
-			return fmt.Errorf("error creating photo: %v", err)
+			return fmt.Errorf("error answering from knowledge: %w", err)
 		}
 		return nil
 
@@ -101,9 +118,10 @@ func AssistantMode(currentUpdate tgbotapi.Update, updates tgbotapi.UpdatesChanne
 
 }
 
-func chatFlow(updates tgbotapi.UpdatesChannel, bot *tgbotapi.BotAPI, chatID int64) error {
+func chatFlow(ctx context.Context, bot telegram.Messenger, chatID int64, kb *Knowledge) error {
 
-	bot.Send(tgbotapi.NewMessage(chatID, "Assistant mode activated."))
+	f := newFlowSession(bot, chatID)
+	f.send(ctx, "Assistant mode activated.", f.actions(ResetCommand, ExitCommand))
 
 	systemPrompt, err := model.LoadPromptFromFile("system")
 	if err != nil {
@@ -111,14 +129,69 @@ func chatFlow(updates tgbotapi.UpdatesChannel, bot *tgbotapi.BotAPI, chatID int6
 	}
 
 	messages := []Message{}
-	messages = append(messages, Message{Role: "system", Content: systemPrompt})
-
-	for update := range updates {
+	messages = append(messages, Message{Role: "system", Content: TextContent(systemPrompt)})
+	voice := voiceOff
+	// documentSources are the index prefixes of the long documents sent in the conversation,
+	// which are indexed in documents, for this conversation only
+	var documentSources []string
+	documents := conversationKnowledge(kb)
+
+	for {
+		update, _, err := f.next()
+		if err != nil {
+			return err
+		}
 
 		messageText := update.Message.Text
 
-		if messageText == "" || update.Message == nil {
-			bot.Send(tgbotapi.NewMessage(chatID, "Please send a text message."))
+		if isSpeech(update.Message) {
+			bot.Send(tgbotapi.NewChatAction(chatID, "typing"))
+			messageText, err = transcribeMessage(ctx, bot, update.Message)
+			if err != nil {
+				logging.FromContext(ctx).Error("Error transcribing message", "error", err)
+				bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Could not transcribe that: %v", err)))
+				continue
+			}
+			bot.Send(tgbotapi.NewMessage(chatID, "🎙 "+messageText))
+		}
+
+		var image []byte
+		imageType := "image/jpeg"
+		if fileID := photoFileID(update.Message); fileID != "" {
+			bot.Send(tgbotapi.NewChatAction(chatID, "typing"))
+			if update.Message.Document != nil {
+				imageType = update.Message.Document.MimeType
+			}
+			image, err = downloadFile(ctx, bot, fileID)
+			if err != nil {
+				logging.FromContext(ctx).Error("Error downloading photo", "error", err)
+				bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Could not download that photo: %v", err)))
+				continue
+			}
+			messageText = update.Message.Caption
+			if messageText == "" {
+				messageText = DefaultImageQuestion
+			}
+		}
+
+		if doc := update.Message.Document; doc != nil && photoFileID(update.Message) == "" {
+			bot.Send(tgbotapi.NewChatAction(chatID, "typing"))
+			dc, err := readDocument(ctx, bot, chatID, doc, documents)
+			if err != nil {
+				logging.FromContext(ctx).Error("Error reading document", "error", err)
+				bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Could not read that document: %v", err)))
+				continue
+			}
+			messages = append(messages, dc.Messages...)
+			if dc.Source != "" {
+				documentSources = append(documentSources, dc.Source)
+			}
+			bot.Send(tgbotapi.NewMessage(chatID, dc.Messages[len(dc.Messages)-1].Content.String()))
+			continue
+		}
+
+		if messageText == "" {
+			bot.Send(tgbotapi.NewMessage(chatID, "Please send a text, voice or photo message, or a document."))
 			continue
 		}
 
@@ -127,42 +200,132 @@ func chatFlow(updates tgbotapi.UpdatesChannel, bot *tgbotapi.BotAPI, chatID int6
 			return nil
 		}
 
+		if mode, ok := parseVoiceCommand(messageText); ok {
+			voice = mode
+			bot.Send(tgbotapi.NewMessage(chatID, "* Voice replies "+strings.Fields(strings.ToLower(messageText))[1]+" *"))
+			continue
+		}
+
 		if strings.ToLower(messageText) == ResetCommand {
 			messages = []Message{}
-			messages = append(messages, Message{Role: "system", Content: systemPrompt})
+			messages = append(messages, Message{Role: "system", Content: TextContent(systemPrompt)})
+			documentSources = nil
+			documents = conversationKnowledge(kb)
 			bot.Send(tgbotapi.NewMessage(chatID, "* Prompt reset *"))
 			continue
 		}
 
-		messages = append(messages, Message{Role: "user", Content: messageText})
+		if urls := web.FindURLs(messageText); len(urls) > 0 && image == nil {
+			question := messageText
+			for _, url := range urls[:min(len(urls), MaxURLsPerMessage)] {
+				bot.Send(tgbotapi.NewChatAction(chatID, "typing"))
+				page, err := pageFetcher.Fetch(ctx, url)
+				if err != nil {
+					logging.FromContext(ctx).Warn("Error fetching page", "url", url, "error", err)
+					bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Could not read %s: %v", url, err)))
+					continue
+				}
+				dc, err := readPage(ctx, bot, chatID, page, documents)
+				if err != nil {
+					logging.FromContext(ctx).Error("Error reading page", "url", url, "error", err)
+					bot.Send(tgbotapi.NewMessage(chatID, model.UserMessage(err)))
+					continue
+				}
+				messages = append(messages, dc.Messages...)
+				if dc.Source != "" {
+					documentSources = append(documentSources, dc.Source)
+				}
+				bot.Send(tgbotapi.NewMessage(chatID, dc.Name+"\n\n"+dc.Messages[len(dc.Messages)-1].Content.String()))
+			}
+			for _, url := range urls {
+				question = strings.ReplaceAll(question, url, "")
+			}
+			// links sent on their own only ask for summaries
+			if strings.TrimSpace(question) == "" {
+				continue
+			}
+		}
+
+		content := TextContent(messageText)
+		if image != nil {
+			content = ImageContent(messageText, image, imageType)
+		}
 
 		bot.Send(tgbotapi.NewChatAction(chatID, "typing"))
 
-		assistantMessage, err := makeChatRequest(
-			messages,
-		)
+		// document parts are only sent with the question they are relevant to
+		question := len(messages)
+		snippets, err := documentSnippets(ctx, documents, documentSources, messageText)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Could not search documents", "error", err)
+		}
+		if snippets != "" && image == nil {
+			messages = append(messages, Message{Role: "user", Content: TextContent(messageText + "\n\n" + snippets)})
+		} else {
+			messages = append(messages, Message{Role: "user", Content: content})
+		}
+
+		var assistantMessage Message
+		assistantMessage, messages, err = runTools(ctx, bot, chatID, messages)
+		// the photo was answered, later turns only need the answer about it
+		messages[question].Content = content.WithoutImages()
 
 		if err != nil {
-			bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Error: %v", err)))
+			logging.FromContext(ctx).Error("Error in chat request", "error", err)
+			bot.Send(tgbotapi.NewMessage(chatID, model.UserMessage(err)))
 		} else {
-			msg := tgbotapi.NewMessage(chatID, assistantMessage.Content)
-			msg.ParseMode = "Markdown"
-			bot.Send(msg)
-			messages = append(messages, assistantMessage)
+			sendText := voice != voiceOnly
+			if voice != voiceOff {
+				if err := sendVoiceReply(ctx, bot, chatID, assistantMessage.Content.String()); err != nil {
+					logging.FromContext(ctx).Error("Error sending voice reply", "error", err)
+					bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Could not speak the answer: %v", err)))
+					sendText = true
+				}
+			}
+			if sendText {
+				msg := tgbotapi.NewMessage(chatID, assistantMessage.Content.String())
+				msg.ParseMode = "Markdown"
+				bot.Send(msg)
+			}
 
 		}
 
 	}
-
-	return nil
 }
 
+// makeChatRequest asks the model for the next message, offering it tools unless the
+// current turn, from the last user message on, has images, which the vision model
+// answers without tools. Images of earlier turns were answered already, so only their
+// text is sent again.
 func makeChatRequest(
+	ctx context.Context,
 	messages []Message,
+	tools []Tool,
 ) (Message, error) {
 
+	turn := 0
+	for i, message := range messages {
+		if message.Role == "user" {
+			turn = i
+		}
+	}
+
+	modelID := ModelID
+	sent := make([]Message, len(messages))
+	for i, message := range messages {
+		switch {
+		case !message.Content.HasImage():
+		case i < turn:
+			message.Content = message.Content.WithoutImages()
+		default:
+			modelID = VisionModelID
+			tools = nil
+		}
+		sent[i] = message
+	}
+
 	apiRequest := ApiRequest{
-		Model:             ModelID,
+		Model:             modelID,
 		MaxTokens:         512,
 		Stop:              []string{"</s>", "[/INST]"},
 		Temperature:       0.0,
@@ -170,16 +333,16 @@ func makeChatRequest(
 		TopK:              50,
 		RepetitionPenalty: 1,
 		N:                 1,
-		Messages:          messages,
+		Messages:          sent,
+		Tools:             toolSpecs(tools),
 	}
 
-	apiKey := os.Getenv("TOGETHER_API_KEY")
-
-	if apiKey == "" {
-		return Message{}, fmt.Errorf("TOGETHER_API_KEY environment variable not set")
+	client, err := model.NewClient()
+	if err != nil {
+		return Message{}, err
 	}
 
-	body, err := sendPostRequest(apiRequest, apiKey, ChatEndpoint)
+	body, err := client.Post(ctx, ChatEndpoint, apiRequest)
 	if err != nil {
 		return Message{}, err
 	}
@@ -198,130 +361,6 @@ func makeChatRequest(
 
 }
 
-func photogenFlow(updates tgbotapi.UpdatesChannel, bot *tgbotapi.BotAPI, chatID int64) error {
-
-	bot.Send(tgbotapi.NewMessage(chatID, "Photo generation mode activated. Go ahead and send your prompt."))
-
-	for update := range updates {
-		switch {
-
-		case strings.ToLower(update.Message.Text) == ExitCommand:
-			sendMessage(update, bot, "Aborting")
-			return nil
-
-		case update.Message.Text != "":
-			genText := update.Message.Text
-			bot.Send(tgbotapi.NewMessage(chatID, "Generating photo for text: "+genText))
-
-			bot.Send(tgbotapi.NewChatAction(chatID, "typing"))
-			imageBytes, err := makePhotoGenRequest(genText)
-			if err != nil {
-				sendMessage(update, bot, fmt.Sprintf("Error: %v", err))
-				return err
-			}
-
-			mediaGroup := []interface{}{}
-			for index, img := range imageBytes {
-				mediaGroup = append(mediaGroup, tgbotapi.NewInputMediaPhoto(tgbotapi.FileBytes{Name: "image" + fmt.Sprintf("%d", index) + ".png", Bytes: img}))
-			}
-
-			bot.SendMediaGroup(tgbotapi.NewMediaGroup(chatID, mediaGroup))
-
-			return nil
-
-		default:
-			sendMessage(update, bot, "That's not a text generation message,")
-			return fmt.Errorf("Invalid message type")
-		}
-	}
-
-	return nil
-}
-
-func makePhotoGenRequest(prompt string) ([][]byte, error) {
-
-	negativePrompt := ""
-	width := 1024
-	height := 1024
-	steps := 40
-	n := 4
-	seed := 9394
-	apiKey := os.Getenv("TOGETHER_API_KEY")
-
-	defaultURL := TogetherEndpoint
-
-	payload := map[string]interface{}{
-		"model":               PhotoGenModelID,
-		"prompt":              prompt,
-		"negative_prompt":     negativePrompt,
-		"width":               width,
-		"height":              height,
-		"num_inference_steps": steps,
-		"n":                   n,
-		"seed":                seed,
-		"steps":               steps,
-	}
-
-	body, err := sendPostRequest(payload, apiKey, defaultURL)
-	if err != nil {
-		return nil, err
-	}
-
-	var apiResponse ApiPhotoGenResponse
-	err = json.Unmarshal(body, &apiResponse)
-	if err != nil {
-		return nil, err
-	}
-
-	// an array of arrays of bytes
-	var images [][]byte
-
-	if len(apiResponse.Output.Choices) != n {
-		return nil, fmt.Errorf("Expected %d images, got %d", n, len(apiResponse.Output.Choices))
-	}
-
-	for _, choice := range apiResponse.Output.Choices {
-		imgBytes, err := base64ToBytes(choice.Image)
-		if err != nil {
-			return nil, err
-		}
-		images = append(images, imgBytes)
-	}
-
-	return images, nil
-
-}
-
-func sendPostRequest(payload interface{}, apiKey, defaultURL string) ([]byte, error) {
-	bytesPayload, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequest("POST", defaultURL, bytes.NewReader(bytesPayload))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	return body, nil
-
-}
-
 func base64ToBytes(base64Str string) ([]byte, error) {
 	data, err := base64.StdEncoding.DecodeString(base64Str)
 	if err != nil {
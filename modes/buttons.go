@@ -0,0 +1,219 @@
+package modes
+
+import (
+	"context"
+	"strings"
+
+	"duarteocarmo/ambrosio/logging"
+	"duarteocarmo/ambrosio/telegram"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ButtonVersion starts the data of every inline button. It changes with the format,
+// so buttons sent by an older version are answered as expired instead of misread.
+const ButtonVersion = "b1"
+
+// Button kinds, which decide what handles a press.
+const (
+	// ButtonFlow buttons stand for typing a keyword, like exit or skip, in a flow.
+	ButtonFlow = "flow"
+	// ButtonGen buttons act on an image generated in photogen mode.
+	ButtonGen = "gen"
+	// ButtonTool buttons confirm or decline a tool call.
+	ButtonTool = "tool"
+	// ButtonReminder buttons snooze, complete or cancel a reminder at any time.
+	ButtonReminder = "rem"
+	// ButtonCommand buttons run a command with a subcommand, like /assistant chat.
+	ButtonCommand = "cmd"
+)
+
+const (
+	SkipCommand    = "skip"
+	ConfirmCommand = "yes"
+
+	ExpiredButton = "This button has expired."
+)
+
+var actionLabels = map[string]string{
+	ExitCommand:     "✖️ Exit",
+	SkipCommand:     "⏭ Skip",
+	ResetCommand:    "🔄 Reset",
+	SettingsCommand: "⚙️ Settings",
+	HistoryCommand:  "🕘 History",
+	ConfirmCommand:  "✅ Yes",
+	SuggestCommand:  "✨ Suggest",
+	SuggestionUse:   "✅ Use it",
+	SuggestionEdit:  "✏️ Edit",
+}
+
+// stickyActions keep their buttons after a press, since they can be used again.
+var stickyActions = map[string]bool{ResetCommand: true, SettingsCommand: true, HistoryCommand: true, SuggestionEdit: true}
+
+// ButtonData is what an inline button carries: its kind, the session it belongs to
+// and arguments. It holds everything needed to act on a press, so buttons still work
+// after a restart. Buttons without a session outlive the flow that sent them.
+type ButtonData struct {
+	Kind    string
+	Session string
+	Args    []string
+}
+
+// String encodes the data as version:kind:session:args..., which must fit in the 64
+// bytes Telegram allows, so arguments are short IDs and keywords without colons.
+func (d ButtonData) String() string {
+	return strings.Join(append([]string{ButtonVersion, d.Kind, d.Session}, d.Args...), ":")
+}
+
+// ParseButtonData decodes the data of a pressed button, reporting false for data of
+// another version or format.
+func ParseButtonData(data string) (ButtonData, bool) {
+	parts := strings.Split(data, ":")
+	if len(parts) < 3 || parts[0] != ButtonVersion || parts[1] == "" {
+		return ButtonData{}, false
+	}
+	return ButtonData{Kind: parts[1], Session: parts[2], Args: parts[3:]}, true
+}
+
+// Arg returns the i-th argument, or "" when there are fewer.
+func (d ButtonData) Arg(i int) string {
+	if i < len(d.Args) {
+		return d.Args[i]
+	}
+	return ""
+}
+
+func newButton(text string, data ButtonData) tgbotapi.InlineKeyboardButton {
+	return tgbotapi.NewInlineKeyboardButtonData(text, data.String())
+}
+
+// CommandButton runs /command subcommand when pressed.
+func CommandButton(text, command, subcommand string) tgbotapi.InlineKeyboardButton {
+	return newButton(text, ButtonData{Kind: ButtonCommand, Args: []string{command, subcommand}})
+}
+
+// RemoveKeyboard takes the buttons off the message of a pressed button, so it can't be
+// pressed twice.
+func RemoveKeyboard(bot telegram.Messenger, query *tgbotapi.CallbackQuery) {
+	if query.Message != nil {
+		bot.Request(tgbotapi.NewEditMessageReplyMarkup(query.Message.Chat.ID, query.Message.MessageID, tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}))
+	}
+}
+
+// flowSession identifies a running flow, so presses of its buttons can be told apart
+// from those of earlier flows, including flows from before a restart, and from those
+// of its earlier steps.
+type flowSession struct {
+	id     string
+	bot    telegram.Messenger
+	chatID int64
+	// step is the part of the flow waiting for input; buttons of other steps are stale.
+	step string
+	// accepted are the kinds of the session's buttons, besides flow buttons, that next
+	// returns as they are, for flows handling the presses themselves.
+	accepted map[string]bool
+}
+
+func newFlowSession(bot telegram.Messenger, chatID int64) *flowSession {
+	return &flowSession{id: logging.NewCorrelationID()[:6], bot: bot, chatID: chatID}
+}
+
+// button returns a button of kind belonging to this session.
+func (f *flowSession) button(text, kind string, args ...string) tgbotapi.InlineKeyboardButton {
+	return newButton(text, ButtonData{Kind: kind, Session: f.id, Args: args})
+}
+
+// action returns a button pressed instead of typing action in the current step.
+func (f *flowSession) action(text, action string) tgbotapi.InlineKeyboardButton {
+	if text == "" {
+		text = actionLabels[action]
+	}
+	return f.button(text, ButtonFlow, f.step, action)
+}
+
+// actions returns a keyboard row with a button for each action.
+func (f *flowSession) actions(actions ...string) []tgbotapi.InlineKeyboardButton {
+	row := []tgbotapi.InlineKeyboardButton{}
+	for _, a := range actions {
+		row = append(row, f.action("", a))
+	}
+	return row
+}
+
+// accept makes next return presses of the session's buttons of kinds as they are.
+func (f *flowSession) accept(kinds ...string) {
+	if f.accepted == nil {
+		f.accepted = map[string]bool{}
+	}
+	for _, kind := range kinds {
+		f.accepted[kind] = true
+	}
+}
+
+// start begins step, which the action buttons made afterwards belong to.
+func (f *flowSession) start(step string) {
+	f.step = step
+}
+
+// send sends text with a keyboard of rows, skipping empty ones.
+func (f *flowSession) send(ctx context.Context, text string, rows ...[]tgbotapi.InlineKeyboardButton) {
+	msg := tgbotapi.NewMessage(f.chatID, text)
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	for _, row := range rows {
+		if len(row) > 0 {
+			keyboard = append(keyboard, row)
+		}
+	}
+	if len(keyboard) > 0 {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	}
+	if _, err := f.bot.Send(msg); err != nil {
+		logging.FromContext(ctx).Error("Error sending message", "chat_id", f.chatID, "error", err)
+	}
+}
+
+// next waits for the next message, or press of one of the session's buttons. A flow
+// button of the current step comes back as the message typing its action would have
+// sent, with the action, so steps can mostly deal with text; buttons of the session of
+// an accepted kind come back as they are. Any other press is answered as expired, so
+// updates without a message only reach flows that accepted them.
+func (f *flowSession) next() (tgbotapi.Update, string, error) {
+	for {
+		update, err := nextInput(f.bot.Updates(), f.chatID)
+		if err != nil {
+			return tgbotapi.Update{}, "", err
+		}
+
+		query := update.CallbackQuery
+		if query == nil {
+			return update, "", nil
+		}
+
+		data, ok := ParseButtonData(query.Data)
+		switch {
+		case ok && data.Kind == ButtonCommand:
+			f.bot.Request(tgbotapi.NewCallback(query.ID, "Exit the current mode first."))
+
+		case !ok || data.Session != f.id:
+			f.bot.Request(tgbotapi.NewCallback(query.ID, ExpiredButton))
+
+		case data.Kind != ButtonFlow && f.accepted[data.Kind]:
+			return update, "", nil
+
+		case data.Kind != ButtonFlow || data.Arg(0) != f.step:
+			f.bot.Request(tgbotapi.NewCallback(query.ID, ExpiredButton))
+
+		default:
+			action := data.Arg(1)
+			f.bot.Request(tgbotapi.NewCallback(query.ID, ""))
+			if !stickyActions[action] {
+				RemoveKeyboard(f.bot, query)
+			}
+			return tgbotapi.Update{UpdateID: update.UpdateID, Message: &tgbotapi.Message{
+				From: query.From,
+				Chat: &tgbotapi.Chat{ID: f.chatID, Type: "private"},
+				Text: action,
+			}}, action, nil
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package modes
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"duarteocarmo/ambrosio/telegram/telegramtest"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// chdirRoot runs the test from the repository root, where the prompts are.
+func chdirRoot(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(".."); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// lastSession returns the session of the last flow button the bot sent.
+func lastSession(t *testing.T, bot *telegramtest.Fake) string {
+	t.Helper()
+	sent := bot.Sent()
+	for i := len(sent) - 1; i >= 0; i-- {
+		m, ok := sent[i].(tgbotapi.MessageConfig)
+		if !ok {
+			continue
+		}
+		if keyboard, ok := m.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup); ok {
+			if data, ok := ParseButtonData(*keyboard.InlineKeyboard[0][0].CallbackData); ok {
+				return data.Session
+			}
+		}
+	}
+	t.Fatalf("bot sent no buttons, sent: %q", bot.Texts())
+	return ""
+}
+
+func TestChatFlowExpiresOtherButtonsOfItsSession(t *testing.T) {
+	chdirRoot(t)
+	bot := telegramtest.NewFake(1, "owner")
+	wait := runFlow(t, func() error { return chatFlow(context.Background(), bot, bot.ChatID, &Knowledge{}) })
+
+	bot.Expect(t, "Assistant mode activated.")
+	session := lastSession(t, bot)
+	// a generation button carrying the chat's session, as a replayed press would
+	bot.PushButton(ButtonData{Kind: ButtonGen, Session: session, Args: []string{"0", "var", "0"}}.String())
+	bot.PushText("exit")
+	bot.Expect(t, "Assistant mode deactivated.")
+
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range bot.Sent() {
+		if callback, ok := c.(tgbotapi.CallbackConfig); ok && callback.Text == ExpiredButton {
+			return
+		}
+	}
+	t.Error("the press was not answered as expired")
+}
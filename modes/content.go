@@ -0,0 +1,97 @@
+package modes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// Content is the content of a chat message: plain text, or for multimodal
+// messages a list of text and image parts.
+type Content struct {
+	Text  string
+	Parts []ContentPart
+}
+
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+type ImageURL struct {
+	URL string `json:"url"`
+}
+
+func TextContent(text string) Content {
+	return Content{Text: text}
+}
+
+// ImageContent asks about an image, sent inline as a data URL.
+func ImageContent(text string, image []byte, mimeType string) Content {
+	dataURL := "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(image)
+	return Content{Parts: []ContentPart{
+		{Type: "text", Text: text},
+		{Type: "image_url", ImageURL: &ImageURL{URL: dataURL}},
+	}}
+}
+
+// String returns the text of the content, leaving out images.
+func (c Content) String() string {
+	if c.Parts == nil {
+		return c.Text
+	}
+
+	var texts []string
+	for _, part := range c.Parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func (c Content) HasImage() bool {
+	for _, part := range c.Parts {
+		if part.ImageURL != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// WithoutImages replaces content with images by its text, marked as having come with
+// an image.
+func (c Content) WithoutImages() Content {
+	if !c.HasImage() {
+		return c
+	}
+	return TextContent("[image] " + c.String())
+}
+
+func (c Content) MarshalJSON() ([]byte, error) {
+	if c.Parts == nil {
+		return json.Marshal(c.Text)
+	}
+	return json.Marshal(c.Parts)
+}
+
+func (c *Content) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*c = Content{}
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = Content{Text: text}
+		return nil
+	}
+
+	var parts []ContentPart
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+	*c = Content{Parts: parts}
+	return nil
+}
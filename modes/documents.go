@@ -0,0 +1,204 @@
+package modes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"duarteocarmo/ambrosio/logging"
+	"duarteocarmo/ambrosio/rag"
+	"duarteocarmo/ambrosio/telegram"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ledongthuc/pdf"
+)
+
+const (
+	// StuffLimit is the longest document, in bytes, put into the conversation whole.
+	// Longer documents are summarised and indexed for retrieval.
+	StuffLimit = 12000
+	// SummaryChunkSize is the size of the parts long documents are summarised in.
+	SummaryChunkSize = 8000
+	// MaxSummaryChunks caps the model calls spent summarising a document.
+	MaxSummaryChunks = 25
+)
+
+// documentContext is what a conversation learns from a document.
+type documentContext struct {
+	Name string
+	// Messages introduce the document to the model.
+	Messages []Message
+	// Source is the index prefix of the document chunks, if they were indexed.
+	Source string
+}
+
+// extractText returns the text of a PDF, Markdown or plain-text file.
+func extractText(name, mimeType string, data []byte) (string, error) {
+	ext := strings.ToLower(path.Ext(name))
+
+	switch {
+	case mimeType == "application/pdf" || ext == ".pdf":
+		reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return "", fmt.Errorf("error reading PDF: %w", err)
+		}
+		plain, err := reader.GetPlainText()
+		if err != nil {
+			return "", fmt.Errorf("error extracting PDF text: %w", err)
+		}
+		text, err := io.ReadAll(plain)
+		if err != nil {
+			return "", fmt.Errorf("error extracting PDF text: %w", err)
+		}
+		return string(text), nil
+
+	case strings.HasPrefix(mimeType, "text/") || ext == ".md" || ext == ".txt":
+		if !utf8.Valid(data) {
+			return "", fmt.Errorf("the file is not UTF-8 text")
+		}
+		return string(data), nil
+
+	default:
+		return "", fmt.Errorf("only PDF, Markdown and text files are supported")
+	}
+}
+
+// readDocument downloads a document sent in chat mode. Short documents go into the
+// conversation whole; long ones are summarised part by part and, when an index is
+// available, indexed so questions can retrieve the relevant parts. The chunks are keyed
+// on the file, so documents with the same name don't replace each other.
+func readDocument(ctx context.Context, bot telegram.Messenger, chatID int64, doc *tgbotapi.Document, kb *Knowledge) (documentContext, error) {
+	data, err := downloadFile(ctx, bot, doc.FileID)
+	if err != nil {
+		return documentContext{}, err
+	}
+
+	text, err := extractText(doc.FileName, doc.MimeType, data)
+	if err != nil {
+		return documentContext{}, err
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return documentContext{}, fmt.Errorf("no text found in %s", doc.FileName)
+	}
+
+	dc := documentContext{Name: doc.FileName}
+
+	if len(text) <= StuffLimit {
+		dc.Messages = []Message{
+			{Role: "user", Content: TextContent(fmt.Sprintf("Here is the document %s. I'll ask you about it next.\n\n%s", doc.FileName, text))},
+			{Role: "assistant", Content: TextContent(fmt.Sprintf("I've read %s. What would you like to know?", doc.FileName))},
+		}
+		return dc, nil
+	}
+
+	summary, err := summariseDocument(ctx, bot, chatID, doc.FileName, text)
+	if err != nil {
+		return documentContext{}, err
+	}
+
+	if kb != nil && kb.Index != nil {
+		source := fmt.Sprintf("doc %s %s #", doc.FileUniqueID, doc.FileName)
+		if err := indexDocument(ctx, kb, source, text); err != nil {
+			logging.FromContext(ctx).Warn("Could not index document, answering from its summary", "error", err)
+		} else {
+			dc.Source = source
+		}
+	}
+
+	dc.Messages = []Message{
+		{Role: "user", Content: TextContent(fmt.Sprintf("Here is a summary of the document %s, which is too long to read whole. Relevant parts will come with my questions.\n\n%s", doc.FileName, summary))},
+		{Role: "assistant", Content: TextContent(fmt.Sprintf("I've read the summary of %s. What would you like to know?", doc.FileName))},
+	}
+	return dc, nil
+}
+
+// summariseDocument summarises text part by part, refining a running summary, and
+// shows the progress in a message it keeps editing.
+func summariseDocument(ctx context.Context, bot telegram.Messenger, chatID int64, name, text string) (string, error) {
+	parts := rag.Chunk(text, SummaryChunkSize)
+	truncated := len(parts) > MaxSummaryChunks
+	if truncated {
+		parts = parts[:MaxSummaryChunks]
+	}
+
+	progress, _ := bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("%s is long, summarising it in %d parts...", name, len(parts))))
+
+	summary := ""
+	for i, part := range parts {
+		bot.Send(tgbotapi.NewChatAction(chatID, "typing"))
+
+		prompt := fmt.Sprintf("Summarise part %d of %d of the document %s in under 300 words, keeping names, numbers and conclusions.\n\n%s", i+1, len(parts), name, part)
+		if summary != "" {
+			prompt = fmt.Sprintf("Here is the summary of the document %s so far:\n\n%s\n\nUpdate it with part %d of %d below, in under 300 words, keeping names, numbers and conclusions.\n\n%s", name, summary, i+1, len(parts), part)
+		}
+
+		answer, err := makeChatRequest(ctx, []Message{{Role: "user", Content: TextContent(prompt)}}, nil)
+		if err != nil {
+			return "", fmt.Errorf("error summarising part %d: %w", i+1, err)
+		}
+		summary = strings.TrimSpace(answer.Content.String())
+
+		if progress.MessageID != 0 {
+			bot.Send(tgbotapi.NewEditMessageText(chatID, progress.MessageID, fmt.Sprintf("%s is long, summarised %d of %d parts...", name, i+1, len(parts))))
+		}
+	}
+
+	if truncated {
+		summary += fmt.Sprintf("\n\n(Only the first %d parts were summarised.)", MaxSummaryChunks)
+	}
+	return summary, nil
+}
+
+// conversationKnowledge returns an empty in-memory index for the documents of one chat
+// conversation, embedding like kb, so they are dropped with the conversation instead of
+// joining the knowledge ask mode answers from.
+func conversationKnowledge(kb *Knowledge) *Knowledge {
+	documents := &Knowledge{Index: rag.NewIndex()}
+	if kb != nil {
+		documents.Embedder = kb.Embedder
+	}
+	return documents
+}
+
+func indexDocument(ctx context.Context, kb *Knowledge, source, text string) error {
+	embedder := kb.Embedder
+	if embedder == nil {
+		var err error
+		if embedder, err = rag.NewEmbedder(); err != nil {
+			return err
+		}
+	}
+
+	var docs []rag.Document
+	for i, chunk := range rag.Chunk(text, rag.ChunkSize) {
+		docs = append(docs, rag.Document{Source: fmt.Sprintf("%s%d", source, i+1), Text: chunk})
+	}
+	return kb.Index.Sync(ctx, embedder, source, docs)
+}
+
+// documentSnippets returns the parts of the indexed documents relevant to question,
+// to add to it.
+func documentSnippets(ctx context.Context, kb *Knowledge, sources []string, question string) (string, error) {
+	if len(sources) == 0 || kb == nil || kb.Index == nil {
+		return "", nil
+	}
+
+	embedder := kb.Embedder
+	if embedder == nil {
+		var err error
+		if embedder, err = rag.NewEmbedder(); err != nil {
+			return "", err
+		}
+	}
+
+	results, err := kb.Index.Search(ctx, embedder, question, RetrievalResults, MinRelevance, sources...)
+	if err != nil || len(results) == 0 {
+		return "", err
+	}
+	return "Relevant parts of the documents:\n\n" + formatSnippets(results), nil
+}
@@ -0,0 +1,48 @@
+package modes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"duarteocarmo/ambrosio/telegram"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// MaxDownloadSize matches the largest file the Bot API lets bots download.
+const MaxDownloadSize = 20 << 20
+
+// downloadFile fetches the contents of a file the user sent.
+func downloadFile(ctx context.Context, bot telegram.Messenger, fileID string) ([]byte, error) {
+	file, err := bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		return nil, fmt.Errorf("error getting file: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", bot.FileURL(file), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading file: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxDownloadSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error downloading file: %w", err)
+	}
+	if len(data) > MaxDownloadSize {
+		return nil, fmt.Errorf("file is larger than %d bytes", MaxDownloadSize)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,210 @@
+package modes
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"duarteocarmo/ambrosio/logging"
+	"duarteocarmo/ambrosio/model"
+	"duarteocarmo/ambrosio/telegram"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// MaxThreadMessages is how many messages of a group thread are sent to the model.
+	MaxThreadMessages = 20
+	// MaxGroupThreads is how many threads are remembered, across all groups.
+	MaxGroupThreads = 100
+)
+
+type chatMessage struct {
+	ChatID    int64
+	MessageID int
+}
+
+type groupThread struct {
+	messages []Message
+	used     time.Time
+}
+
+// GroupChats holds the conversations the bot has in groups. A message mentioning the
+// bot starts a thread and replies to its answers continue it, so conversations going
+// on at the same time in a group keep their own context.
+//
+// With Telegram's privacy mode on, bots only see commands and replies to their own
+// messages, so privacy mode must be turned off with BotFather for mentions to work.
+type GroupChats struct {
+	Bot  telegram.Messenger
+	Self tgbotapi.User
+
+	threads map[chatMessage]*groupThread
+	// inThread maps every message of a thread to the message that started it.
+	inThread map[chatMessage]chatMessage
+}
+
+func NewGroupChats(bot telegram.Messenger, self tgbotapi.User) *GroupChats {
+	return &GroupChats{
+		Bot:      bot,
+		Self:     self,
+		threads:  map[chatMessage]*groupThread{},
+		inThread: map[chatMessage]chatMessage{},
+	}
+}
+
+// Addressed reports whether m is meant for the bot: a command without a bot name or
+// with the bot's, a mention, or a reply to one of the bot's messages.
+func (g *GroupChats) Addressed(m *tgbotapi.Message) bool {
+	if m.IsCommand() {
+		command := m.CommandWithAt()
+		at := strings.Index(command, "@")
+		return at < 0 || strings.EqualFold(command[at+1:], g.Self.UserName)
+	}
+	if m.ReplyToMessage != nil && m.ReplyToMessage.From != nil && m.ReplyToMessage.From.ID == g.Self.ID {
+		return true
+	}
+	return g.mentioned(m.Text, m.Entities) || g.mentioned(m.Caption, m.CaptionEntities)
+}
+
+func (g *GroupChats) mentioned(text string, entities []tgbotapi.MessageEntity) bool {
+	units := utf16.Encode([]rune(text))
+	for _, e := range entities {
+		switch e.Type {
+		case "mention":
+			if e.Offset+e.Length > len(units) {
+				continue
+			}
+			mention := string(utf16.Decode(units[e.Offset : e.Offset+e.Length]))
+			if strings.EqualFold(mention, "@"+g.Self.UserName) {
+				return true
+			}
+		case "text_mention":
+			if e.User != nil && e.User.ID == g.Self.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Handle answers a message addressed to the bot in a group, in the thread it belongs
+// to. It is not safe for concurrent use, messages are handled one at a time.
+func (g *GroupChats) Handle(ctx context.Context, m *tgbotapi.Message) error {
+	text := g.stripMention(m.Text)
+	if text == "" {
+		text = g.stripMention(m.Caption)
+	}
+	if text == "" {
+		g.reply(ctx, m, "Yes? Mention me with a question, or reply to one of my messages.")
+		return nil
+	}
+
+	root, thread, err := g.thread(m)
+	if err != nil {
+		return err
+	}
+
+	content := fmt.Sprintf("%s: %s", m.From.String(), text)
+	if r := m.ReplyToMessage; r != nil && r.From != nil && r.From.ID != g.Self.ID && r.Text != "" {
+		content = fmt.Sprintf("%s, replying to %s who said %q: %s", m.From.String(), r.From.String(), r.Text, text)
+	}
+	thread.messages = recentMessages(append(thread.messages, Message{Role: "user", Content: TextContent(content)}))
+	thread.used = time.Now()
+	g.inThread[chatMessage{m.Chat.ID, m.MessageID}] = root
+
+	g.Bot.Send(tgbotapi.NewChatAction(m.Chat.ID, "typing"))
+	answer, err := makeChatRequest(ctx, thread.messages, nil)
+	if err != nil {
+		// the question stays in the thread so a reply can retry it
+		logging.FromContext(ctx).Error("Error in group chat request", "error", err)
+		g.reply(ctx, m, model.UserMessage(err))
+		return nil
+	}
+
+	thread.messages = append(thread.messages, Message{Role: "assistant", Content: answer.Content})
+	if sent, ok := g.reply(ctx, m, answer.Content.String()); ok {
+		g.inThread[chatMessage{m.Chat.ID, sent.MessageID}] = root
+	}
+	return nil
+}
+
+// thread returns the thread m continues, or starts one. Replies to bot messages from
+// before a restart start a thread with the replied message as context.
+func (g *GroupChats) thread(m *tgbotapi.Message) (chatMessage, *groupThread, error) {
+	if r := m.ReplyToMessage; r != nil {
+		if root, ok := g.inThread[chatMessage{m.Chat.ID, r.MessageID}]; ok {
+			if thread, ok := g.threads[root]; ok {
+				return root, thread, nil
+			}
+		}
+	}
+
+	systemPrompt, err := model.LoadPromptFromFile("system")
+	if err != nil {
+		return chatMessage{}, nil, err
+	}
+	groupPrompt, err := model.LoadPromptFromFile("group")
+	if err != nil {
+		return chatMessage{}, nil, err
+	}
+
+	thread := &groupThread{messages: []Message{{Role: "system", Content: TextContent(systemPrompt + "\n\n" + groupPrompt)}}}
+	if r := m.ReplyToMessage; r != nil && r.From != nil && r.From.ID == g.Self.ID && r.Text != "" {
+		thread.messages = append(thread.messages, Message{Role: "assistant", Content: TextContent(r.Text)})
+	}
+
+	root := chatMessage{m.Chat.ID, m.MessageID}
+	g.threads[root] = thread
+	g.forgetOldThreads()
+	return root, thread, nil
+}
+
+// forgetOldThreads drops the least recently used threads beyond MaxGroupThreads.
+func (g *GroupChats) forgetOldThreads() {
+	for len(g.threads) > MaxGroupThreads {
+		var oldest chatMessage
+		var oldestUsed time.Time
+		for root, thread := range g.threads {
+			if oldestUsed.IsZero() || thread.used.Before(oldestUsed) {
+				oldest, oldestUsed = root, thread.used
+			}
+		}
+		delete(g.threads, oldest)
+		for message, root := range g.inThread {
+			if root == oldest {
+				delete(g.inThread, message)
+			}
+		}
+	}
+}
+
+func (g *GroupChats) stripMention(text string) string {
+	if g.Self.UserName != "" {
+		mention := regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(g.Self.UserName) + `\b`)
+		text = mention.ReplaceAllString(text, "")
+	}
+	return strings.TrimSpace(text)
+}
+
+func (g *GroupChats) reply(ctx context.Context, m *tgbotapi.Message, text string) (tgbotapi.Message, bool) {
+	msg := tgbotapi.NewMessage(m.Chat.ID, text)
+	msg.ReplyToMessageID = m.MessageID
+	sent, err := g.Bot.Send(msg)
+	if err != nil {
+		logging.FromContext(ctx).Error("Error sending message", "chat_id", m.Chat.ID, "error", err)
+		return tgbotapi.Message{}, false
+	}
+	return sent, true
+}
+
+// recentMessages keeps the system prompt and the last MaxThreadMessages messages.
+func recentMessages(messages []Message) []Message {
+	if len(messages) <= MaxThreadMessages+1 {
+		return messages
+	}
+	return append([]Message{messages[0]}, messages[len(messages)-MaxThreadMessages:]...)
+}
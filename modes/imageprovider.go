@@ -0,0 +1,87 @@
+package modes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"duarteocarmo/ambrosio/model"
+)
+
+// ImageRequest asks for images from a prompt, optionally starting from InitImage
+// and repainting only the white areas of Mask.
+type ImageRequest struct {
+	Prompt    string
+	Opts      genOptions
+	InitImage []byte
+	Mask      []byte
+}
+
+// ImageProvider generates images.
+type ImageProvider interface {
+	Generate(ctx context.Context, req ImageRequest) ([][]byte, error)
+}
+
+// imageProvider is the provider used by the photogen flow, replaceable in tests.
+var imageProvider ImageProvider = TogetherImages{ModelID: PhotoGenModelID}
+
+// TogetherImages generates images with the Together inference API.
+type TogetherImages struct {
+	ModelID string
+}
+
+func (t TogetherImages) Generate(ctx context.Context, req ImageRequest) ([][]byte, error) {
+	client, err := model.NewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := req.Opts
+	payload := map[string]interface{}{
+		"model":               t.ModelID,
+		"prompt":              req.Prompt,
+		"negative_prompt":     opts.NegativePrompt,
+		"width":               opts.Width,
+		"height":              opts.Height,
+		"num_inference_steps": opts.Steps,
+		"n":                   opts.N,
+		"seed":                opts.Seed,
+		"steps":               opts.Steps,
+	}
+	if req.InitImage != nil {
+		payload["image_base64"] = base64.StdEncoding.EncodeToString(req.InitImage)
+		payload["strength"] = opts.Strength
+	}
+	if req.Mask != nil {
+		payload["mask_image_base64"] = base64.StdEncoding.EncodeToString(req.Mask)
+	}
+
+	body, err := client.Post(ctx, TogetherEndpoint, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiResponse ApiPhotoGenResponse
+	err = json.Unmarshal(body, &apiResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	// an array of arrays of bytes
+	var images [][]byte
+
+	if len(apiResponse.Output.Choices) != opts.N {
+		return nil, fmt.Errorf("Expected %d images, got %d", opts.N, len(apiResponse.Output.Choices))
+	}
+
+	for _, choice := range apiResponse.Output.Choices {
+		imgBytes, err := base64ToBytes(choice.Image)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, imgBytes)
+	}
+
+	return images, nil
+}
@@ -0,0 +1,267 @@
+package modes
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"duarteocarmo/ambrosio/logging"
+	"duarteocarmo/ambrosio/model"
+	"duarteocarmo/ambrosio/storage"
+	"duarteocarmo/ambrosio/telegram"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// InlineDebounce is how long a query must stay unchanged before the model is asked,
+	// so partial queries typed on the way are not answered.
+	InlineDebounce = 700 * time.Millisecond
+	// InlineTimeout keeps answers within the time Telegram waits for them.
+	InlineTimeout = 8 * time.Second
+	// MinInlineQuestion is the shortest query sent to the model.
+	MinInlineQuestion = 3
+	// InlinePhotosCommand starts queries for gallery photos, like "photos lisbon".
+	InlinePhotosCommand = "photos"
+	MaxInlinePhotos     = 20
+
+	InlineCacheSize     = 100
+	InlineAnswerTTL     = 10 * time.Minute
+	InlinePhotosTTL     = 5 * time.Minute
+	InlineAnswerSeconds = 300
+	InlinePhotosSeconds = 60
+)
+
+type inlineAnswer struct {
+	text    string
+	expires time.Time
+}
+
+// Inline answers inline queries (@bot question) with model answers, or with recent
+// gallery photos for empty and "photos" queries.
+type Inline struct {
+	Bot telegram.Messenger
+	// PhotosURL is the public base URL of the photo bucket. Photos are only offered
+	// when it is set, since Telegram downloads inline photos itself.
+	PhotosURL string
+
+	mu       sync.Mutex
+	latest   map[int64]string
+	answers  map[string]inlineAnswer
+	photos   []storage.Photo
+	photosAt time.Time
+}
+
+func NewInline(bot telegram.Messenger, photosURL string) *Inline {
+	return &Inline{
+		Bot:       bot,
+		PhotosURL: strings.TrimRight(photosURL, "/"),
+		latest:    map[int64]string{},
+		answers:   map[string]inlineAnswer{},
+	}
+}
+
+// Handle answers query. Telegram sends a query for every keystroke, so questions are
+// only answered once the user stops typing for InlineDebounce, and answers are cached.
+func (i *Inline) Handle(ctx context.Context, query *tgbotapi.InlineQuery) {
+	text := strings.TrimSpace(query.Query)
+	question := strings.ToLower(text) != InlinePhotosCommand && !strings.HasPrefix(strings.ToLower(text), InlinePhotosCommand+" ")
+	_, cached := i.cachedAnswer(text)
+
+	if text != "" && question && !cached {
+		i.mu.Lock()
+		i.latest[query.From.ID] = query.ID
+		i.mu.Unlock()
+
+		select {
+		case <-time.After(InlineDebounce):
+		case <-ctx.Done():
+			return
+		}
+
+		i.mu.Lock()
+		superseded := i.latest[query.From.ID] != query.ID
+		i.mu.Unlock()
+		if superseded {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, InlineTimeout)
+	defer cancel()
+
+	answer := tgbotapi.InlineConfig{InlineQueryID: query.ID, IsPersonal: true, Results: []interface{}{}}
+	switch {
+	case !question || text == "":
+		words := strings.Fields(strings.ToLower(text))
+		if len(words) > 0 {
+			words = words[1:]
+		}
+		answer.Results = i.photoResults(ctx, words)
+		answer.CacheTime = InlinePhotosSeconds
+	case len([]rune(text)) < MinInlineQuestion:
+	default:
+		result, ok := i.answerResult(ctx, text)
+		answer.Results = append(answer.Results, result)
+		if ok {
+			answer.CacheTime = InlineAnswerSeconds
+		}
+	}
+
+	if _, err := i.Bot.Request(answer); err != nil {
+		logging.FromContext(ctx).Error("Error answering inline query", "error", err)
+	}
+}
+
+// answerResult asks the model the question, returning an article with the answer or
+// the error and whether it is worth caching.
+func (i *Inline) answerResult(ctx context.Context, question string) (tgbotapi.InlineQueryResultArticle, bool) {
+	id := fmt.Sprintf("answer:%x", sha1.Sum([]byte(question)))
+
+	answer, ok := i.cachedAnswer(question)
+	if !ok {
+		var err error
+		answer, err = askInline(ctx, question)
+		if err != nil {
+			logging.FromContext(ctx).Error("Error answering inline question", "error", err)
+			result := tgbotapi.NewInlineQueryResultArticle(id, "Could not answer", model.UserMessage(err))
+			result.Description = model.UserMessage(err)
+			return result, false
+		}
+		i.cacheAnswer(question, answer)
+	}
+
+	result := tgbotapi.NewInlineQueryResultArticle(id, question, answer)
+	result.Description = truncate(answer, 200)
+	return result, true
+}
+
+func askInline(ctx context.Context, question string) (string, error) {
+	prompt, err := model.LoadPromptFromFile("inline")
+	if err != nil {
+		return "", err
+	}
+
+	answer, err := makeChatRequest(ctx, []Message{
+		{Role: "system", Content: TextContent(prompt)},
+		{Role: "user", Content: TextContent(question)},
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	text := strings.TrimSpace(answer.Content.String())
+	if text == "" {
+		return "", fmt.Errorf("the model returned an empty answer")
+	}
+	return text, nil
+}
+
+func (i *Inline) cachedAnswer(question string) (string, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	a, ok := i.answers[strings.ToLower(question)]
+	if !ok || time.Now().After(a.expires) {
+		return "", false
+	}
+	return a.text, true
+}
+
+func (i *Inline) cacheAnswer(question, answer string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := time.Now()
+	if len(i.answers) >= InlineCacheSize {
+		for q, a := range i.answers {
+			if now.After(a.expires) {
+				delete(i.answers, q)
+			}
+		}
+	}
+	// still full: drop any answer, they are cheap to ask again
+	for q := range i.answers {
+		if len(i.answers) < InlineCacheSize {
+			break
+		}
+		delete(i.answers, q)
+	}
+	i.answers[strings.ToLower(question)] = inlineAnswer{text: answer, expires: now.Add(InlineAnswerTTL)}
+}
+
+// photoResults returns the most recent gallery photos whose caption, location or date
+// contain all words.
+func (i *Inline) photoResults(ctx context.Context, words []string) []interface{} {
+	results := []interface{}{}
+	if i.PhotosURL == "" {
+		return results
+	}
+
+	photos, err := i.recentPhotos(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Error("Error listing photos for inline query", "error", err)
+		return results
+	}
+
+	for _, p := range photos {
+		if len(results) == MaxInlinePhotos {
+			break
+		}
+		caption, location := "", ""
+		if p.Caption != nil {
+			caption = *p.Caption
+		}
+		if p.Location != nil {
+			location = *p.Location
+		}
+		if !containsAll(strings.ToLower(caption+" "+location+" "+p.Date), words) {
+			continue
+		}
+
+		url := fmt.Sprintf("%s/%s.jpg", i.PhotosURL, path.Base(p.ID))
+		result := tgbotapi.NewInlineQueryResultPhotoWithThumb("photo:"+path.Base(p.ID), url, url)
+		result.Caption = caption
+		result.Description = location
+		results = append(results, result)
+	}
+	return results
+}
+
+// recentPhotos lists the gallery, reusing the last listing for InlinePhotosTTL.
+func (i *Inline) recentPhotos(ctx context.Context) ([]storage.Photo, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.photos != nil && time.Since(i.photosAt) < InlinePhotosTTL {
+		return i.photos, nil
+	}
+
+	photos, err := listPhotos(ctx, MaxInlinePhotos*5)
+	if err != nil {
+		return nil, err
+	}
+	i.photos, i.photosAt = photos, time.Now()
+	return photos, nil
+}
+
+func containsAll(text string, words []string) bool {
+	for _, w := range words {
+		if !strings.Contains(text, w) {
+			return false
+		}
+	}
+	return true
+}
+
+func truncate(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,181 @@
+package modes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"duarteocarmo/ambrosio/notes"
+	"duarteocarmo/ambrosio/telegram"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	TodoAdd      = "add"
+	TodoDone     = "done"
+	TodoList     = "list"
+	NotesSearch  = "search"
+	NotesExport  = "export"
+	NotesListMax = 20
+)
+
+const notesUsage = `/note <text> saves a note, #tags included
+/notes lists recent notes, /notes #tag filters them
+/notes search <words> finds notes and to-dos
+/notes export sends everything as Markdown
+/todo add <text>, /todo done <number>, /todo list [#tag]`
+
+// NoteCommand handles /note <text>.
+func NoteCommand(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, store *notes.Store) error {
+	text := strings.TrimSpace(update.Message.CommandArguments())
+	if text == "" {
+		sendMessage(ctx, update, bot, notesUsage)
+		return nil
+	}
+
+	n, err := store.Add(update.Message.Chat.ID, notes.KindNote, text)
+	if err != nil {
+		return fmt.Errorf("error saving note: %w", err)
+	}
+	sendMessage(ctx, update, bot, "📝 Saved note "+describeNote(n))
+	return nil
+}
+
+// NotesCommand handles /notes [#tag | search <query> | export].
+func NotesCommand(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, store *notes.Store) error {
+	chatID := update.Message.Chat.ID
+	args := strings.Fields(update.Message.CommandArguments())
+
+	switch {
+	case len(args) > 0 && strings.ToLower(args[0]) == NotesSearch:
+		query := strings.Join(args[1:], " ")
+		if query == "" {
+			sendMessage(ctx, update, bot, "What should I search for? /notes search <words>")
+			return nil
+		}
+		results := store.Search(chatID, query)
+		if len(results) == 0 {
+			sendMessage(ctx, update, bot, "Nothing matches "+query+".")
+			return nil
+		}
+		sendMessage(ctx, update, bot, formatNotes(fmt.Sprintf("Found %d:", len(results)), results))
+
+	case len(args) > 0 && strings.ToLower(args[0]) == NotesExport:
+		doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: "notes.md", Bytes: []byte(store.Markdown(chatID))})
+		if _, err := bot.Send(doc); err != nil {
+			return fmt.Errorf("error sending notes: %w", err)
+		}
+
+	case len(args) > 0 && strings.HasPrefix(args[0], "#"):
+		list := store.List(chatID, notes.Filter{Tag: args[0]})
+		if len(list) == 0 {
+			sendMessage(ctx, update, bot, "Nothing tagged "+args[0]+".")
+			return nil
+		}
+		sendMessage(ctx, update, bot, formatNotes("Tagged "+args[0]+":", list))
+
+	case len(args) > 0:
+		sendMessage(ctx, update, bot, notesUsage)
+
+	default:
+		list := store.List(chatID, notes.Filter{Kind: notes.KindNote})
+		if len(list) == 0 {
+			sendMessage(ctx, update, bot, "You have no notes. Save one with /note.")
+			return nil
+		}
+		if len(list) > NotesListMax {
+			list = list[len(list)-NotesListMax:]
+		}
+		sendMessage(ctx, update, bot, formatNotes("Your latest notes:", list))
+	}
+	return nil
+}
+
+// TodoCommand handles /todo add|done|list, adding a to-do when the subcommand is left out.
+func TodoCommand(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, store *notes.Store) error {
+	chatID := update.Message.Chat.ID
+	rawArgs := strings.TrimSpace(update.Message.CommandArguments())
+	args := strings.Fields(rawArgs)
+
+	action := TodoList
+	if len(args) > 0 {
+		action = strings.ToLower(args[0])
+	}
+
+	switch action {
+	case TodoList:
+		filter := notes.Filter{Kind: notes.KindTodo}
+		if len(args) > 1 {
+			filter.Tag = args[1]
+		}
+		list := store.List(chatID, filter)
+		if len(list) == 0 {
+			sendMessage(ctx, update, bot, "Nothing to do. Add a to-do with /todo add <text>.")
+			return nil
+		}
+		sendMessage(ctx, update, bot, formatNotes("To do:", list))
+
+	case TodoDone:
+		if len(args) < 2 {
+			sendMessage(ctx, update, bot, "Which one? /todo done <number>")
+			return nil
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+		if err != nil {
+			sendMessage(ctx, update, bot, fmt.Sprintf("%q is not a to-do number.", args[1]))
+			return nil
+		}
+		n, err := store.Complete(chatID, id)
+		if errors.Is(err, notes.ErrNotFound) {
+			sendMessage(ctx, update, bot, fmt.Sprintf("There is no to-do %d.", id))
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error completing to-do: %w", err)
+		}
+		sendMessage(ctx, update, bot, "✅ Done: "+n.Text)
+
+	default:
+		// taken as typed, like notes, so line breaks are kept
+		text := rawArgs
+		if action == TodoAdd {
+			text = strings.TrimSpace(rawArgs[len(args[0]):])
+		}
+		if text == "" {
+			sendMessage(ctx, update, bot, notesUsage)
+			return nil
+		}
+		n, err := store.Add(chatID, notes.KindTodo, text)
+		if err != nil {
+			return fmt.Errorf("error saving to-do: %w", err)
+		}
+		sendMessage(ctx, update, bot, "☑️ Added to-do "+describeNote(n))
+	}
+	return nil
+}
+
+func describeNote(n notes.Note) string {
+	s := fmt.Sprintf("%d: %s", n.ID, n.Text)
+	if len(n.Tags) > 0 {
+		s += " (tags: " + strings.Join(n.Tags, ", ") + ")"
+	}
+	return s
+}
+
+func formatNotes(title string, list []notes.Note) string {
+	lines := []string{title}
+	for _, n := range list {
+		prefix := "📝"
+		if n.Kind == notes.KindTodo {
+			prefix = "☐"
+			if n.Done {
+				prefix = "☑"
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s %d. %s", prefix, n.ID, n.Text))
+	}
+	return strings.Join(lines, "\n")
+}
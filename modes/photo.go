@@ -1,9 +1,11 @@
 package modes
 
 import (
+	"context"
+	"duarteocarmo/ambrosio/logging"
 	"duarteocarmo/ambrosio/storage"
+	"duarteocarmo/ambrosio/telegram"
 	"fmt"
-	"log"
 	"strings"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -15,7 +17,13 @@ const (
 	PhotoModeExit   = "exit"
 )
 
-func PhotoMode(currentUpdate tgbotapi.Update, updates tgbotapi.UpdatesChannel, bot *tgbotapi.BotAPI) error {
+// uploadPhoto and deletePhoto change the bucket, replaceable in tests.
+var (
+	uploadPhoto = (*storage.Photo).Create
+	deletePhoto = storage.DeletePhoto
+)
+
+func PhotoMode(ctx context.Context, currentUpdate tgbotapi.Update, bot telegram.Messenger) error {
 
 	chatID := currentUpdate.Message.Chat.ID
 
@@ -23,24 +31,28 @@ func PhotoMode(currentUpdate tgbotapi.Update, updates tgbotapi.UpdatesChannel, b
 		return fmt.Errorf("the update does not contain a message or text")
 	}
 
-	textParts := strings.Split(currentUpdate.Message.Text, " ")
+	textParts := strings.Fields(currentUpdate.Message.Text)
 	if len(textParts) <= 1 {
 		return fmt.Errorf("No action specified in the message text")
 	}
 
-	selectedAction := textParts[1]
-	log.Printf("Selected action: %s", selectedAction)
+	// the registry accepts subcommands in any case
+	selectedAction := strings.ToLower(textParts[1])
+	logging.FromContext(ctx).Info("Selected action", "action", selectedAction)
 
 	switch selectedAction {
 	case PhotoModeCreate:
-		err := createPhotoFlow(updates, bot, chatID)
+		err := createPhotoFlow(ctx, bot, chatID)
 		if err != nil {
-			return fmt.Errorf("error creating photo: %v", err)
+			return fmt.Errorf("error creating photo: %w", err)
 		}
 		return nil
 
 	case PhotoModeDelete:
-		deletePhotoFlow(updates, bot, chatID)
+		err := deletePhotoFlow(ctx, bot, chatID)
+		if err != nil {
+			return fmt.Errorf("error deleting photo: %w", err)
+		}
 		return nil
 
 	default:
@@ -51,132 +63,202 @@ func PhotoMode(currentUpdate tgbotapi.Update, updates tgbotapi.UpdatesChannel, b
 
 }
 
-func deletePhotoFlow(updates tgbotapi.UpdatesChannel, bot *tgbotapi.BotAPI, chatID int64) error {
+func deletePhotoFlow(ctx context.Context, bot telegram.Messenger, chatID int64) error {
+	f := newFlowSession(bot, chatID)
 
-	sendMessage(tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}}}, bot, "Please send the photo ID to delete")
+	f.start("id")
+	f.send(ctx, "Please send the photo ID to delete", f.actions(ExitCommand))
 
-	for update := range updates {
+	var id string
+	for id == "" {
+		update, _, err := f.next()
+		if err != nil {
+			return err
+		}
 		switch {
 
 		case strings.ToLower(update.Message.Text) == PhotoModeExit:
-			sendMessage(update, bot, "Aborting")
+			sendMessage(ctx, update, bot, "Aborting")
 			return nil
 
 		case update.Message.Text != "":
-			id := update.Message.Text
-			msg, err := storage.DeletePhoto(id)
-			if err != nil {
-				return fmt.Errorf("error deleting photo: %v", err)
-			}
-			sendMessage(update, bot, msg)
-			return nil
+			id = strings.TrimSpace(update.Message.Text)
 
 		default:
-			sendMessage(update, bot, "That's not a valid ID.")
-			return fmt.Errorf("invalid ID")
+			sendMessage(ctx, update, bot, "That's not a valid ID, send the ID as text.")
 		}
 	}
 
-	return nil
+	f.start("confirm")
+	f.send(ctx, fmt.Sprintf("Delete photo %s? This can't be undone.", id), f.actions(ConfirmCommand, ExitCommand))
+	update, _, err := f.next()
+	if err != nil {
+		return err
+	}
+	if strings.ToLower(strings.TrimSpace(update.Message.Text)) != ConfirmCommand {
+		sendMessage(ctx, update, bot, "Aborting")
+		return nil
+	}
 
+	msg, err := deletePhoto(ctx, id)
+	if err != nil {
+		return fmt.Errorf("error deleting photo: %v", err)
+	}
+	sendMessage(ctx, update, bot, msg)
+	return nil
 }
 
-func createPhotoFlow(updates tgbotapi.UpdatesChannel, bot *tgbotapi.BotAPI, chatID int64) error {
+func createPhotoFlow(ctx context.Context, bot telegram.Messenger, chatID int64) error {
 
 	p := storage.Photo{}
+	f := newFlowSession(bot, chatID)
+
+	var image []byte
+	imageType := "image/jpeg"
+	suggestions := photoSuggestions{}
 
 	// receive photo
-	sendMessage(tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}}}, bot, "Please send a photo")
-	for update := range updates {
+	f.start("photo")
+	f.send(ctx, "Please send a photo (send it as a file to keep its location)", f.actions(ExitCommand))
+	for {
+		update, _, err := f.next()
+		if err != nil {
+			return err
+		}
 		switch {
 		case strings.ToLower(update.Message.Text) == PhotoModeExit:
-			sendMessage(update, bot, "Aborting")
+			sendMessage(ctx, update, bot, "Aborting")
 			return nil
-		case update.Message.Photo == nil:
-			sendMessage(update, bot, "That's not a photo.")
+		case photoFileID(update.Message) == "":
+			sendMessage(ctx, update, bot, "That's not a photo.")
 			continue
 		default:
-			photoURL := getPhotoDownloadUrl(update, bot)
+			photoURL, err := getPhotoDownloadUrl(update, bot)
+			if err != nil {
+				return fmt.Errorf("error getting photo url: %v", err)
+			}
 			p.Url = photoURL
-			sendMessage(tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}}}, bot, "Photo received successfully.")
-			break
+			sendMessage(ctx, tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}}}, bot, "Photo received successfully.")
+
+			// downloaded once for suggestions and the upload; the upload falls back to the URL
+			image, err = downloadFile(ctx, bot, photoFileID(update.Message))
+			if err != nil {
+				logging.FromContext(ctx).Warn("Could not download photo, suggestions are off", "error", err)
+				break
+			}
+			p.Data = image
+			if update.Message.Document != nil {
+				imageType = update.Message.Document.MimeType
+			}
 		}
 		break
 	}
 
 	// receive caption
-	sendMessage(tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}}}, bot, "Please send a caption")
-	for update := range updates {
+	askWithSuggestion(ctx, f, "caption", "Please send a caption", "", image != nil)
+	for {
+		update, action, err := f.next()
+		if err != nil {
+			return err
+		}
+		if action == SuggestCommand {
+			bot.Send(tgbotapi.NewChatAction(chatID, "typing"))
+			suggestions = suggestForPhoto(ctx, image, imageType)
+			if suggestions.Caption == "" && suggestions.Location == "" {
+				sendMessage(ctx, update, bot, "I have no suggestions for this photo.")
+			}
+			askWithSuggestion(ctx, f, "caption", "Please send a caption", suggestions.Caption, false)
+			continue
+		}
+		update, ok := suggestionReply(ctx, f, update, action, suggestions.Caption)
+		if !ok {
+			continue
+		}
 		switch {
 		case strings.ToLower(update.Message.Text) == PhotoModeExit:
-			sendMessage(update, bot, "Aborting")
+			sendMessage(ctx, update, bot, "Aborting")
 			return nil
-		case strings.ToLower(update.Message.Text) == "skip":
-			sendMessage(update, bot, "Caption will be empty.")
+		case strings.ToLower(update.Message.Text) == SkipCommand:
+			sendMessage(ctx, update, bot, "Caption will be empty.")
 			break
 		case update.Message.Text != "":
 			caption := update.Message.Text
 			p.Caption = &caption
-			sendMessage(update, bot, "Caption received successfully: "+caption)
+			sendMessage(ctx, update, bot, "Caption received successfully: "+caption)
 		default:
-			sendMessage(update, bot, "That's not a caption.")
+			sendMessage(ctx, update, bot, "That's not a caption.")
 			continue
 		}
 		break
 	}
 
 	// receive location
-	sendMessage(tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}}}, bot, "Waiting to receive location...")
-	for update := range updates {
+	askWithSuggestion(ctx, f, "location", "Waiting to receive location...", suggestions.Location, false)
+	for {
+		update, action, err := f.next()
+		if err != nil {
+			return err
+		}
+		update, ok := suggestionReply(ctx, f, update, action, suggestions.Location)
+		if !ok {
+			continue
+		}
 		switch {
 		case strings.ToLower(update.Message.Text) == PhotoModeExit:
-			sendMessage(update, bot, "Aborting")
+			sendMessage(ctx, update, bot, "Aborting")
 			return nil
 		case update.Message.Venue != nil && update.Message.Venue.Title != "":
 			p.Location = &update.Message.Venue.Title
-		case strings.ToLower(update.Message.Text) == "skip":
-			sendMessage(update, bot, "Location will be empty.")
+		case strings.ToLower(update.Message.Text) == SkipCommand:
+			sendMessage(ctx, update, bot, "Location will be empty.")
 			break
 		case update.Message.Text != "":
 			p.Location = &update.Message.Text
 		default:
-			sendMessage(update, bot, "That's not a location.")
+			sendMessage(ctx, update, bot, "That's not a location.")
 			continue
 		}
 		if p.Location != nil {
-			sendMessage(update, bot, "Location received successfully: "+*p.Location)
+			sendMessage(ctx, update, bot, "Location received successfully: "+*p.Location)
 		}
 		break
 	}
 
-	msg, err := p.Create()
+	msg, err := uploadPhoto(&p, ctx)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("error uploading photo: %v", err)
 	}
-	sendMessage(tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}}}, bot, msg)
+	sendMessage(ctx, tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}}}, bot, msg)
 
 	return nil
 
 }
 
-func getPhotoDownloadUrl(update tgbotapi.Update, bot *tgbotapi.BotAPI) string {
-	p := update.Message.Photo
-
-	fileID := update.Message.Photo[len(p)-1].FileID
-	file, error := bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
-	if error != nil {
-		panic(error)
+// photoFileID returns the largest size of a photo, or an image sent as a file, which keeps its EXIF data.
+func photoFileID(message *tgbotapi.Message) string {
+	switch {
+	case len(message.Photo) > 0:
+		return message.Photo[len(message.Photo)-1].FileID
+	case message.Document != nil && strings.HasPrefix(message.Document.MimeType, "image/"):
+		return message.Document.FileID
+	default:
+		return ""
 	}
+}
 
-	filePath := file.FilePath
-	downloadURL := "https://api.telegram.org/file/bot" + bot.Token + "/" + filePath
+func getPhotoDownloadUrl(update tgbotapi.Update, bot telegram.Messenger) (string, error) {
+	fileID := photoFileID(update.Message)
+	file, err := bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		return "", err
+	}
 
-	return downloadURL
+	return bot.FileURL(file), nil
 }
 
-func sendMessage(update tgbotapi.Update, bot *tgbotapi.BotAPI, text string) {
+func sendMessage(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, text string) {
 	msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
 	if _, err := bot.Send(msg); err != nil {
-		panic(err)
+		logging.FromContext(ctx).Error("Error sending message", "chat_id", update.Message.Chat.ID, "error", err)
 	}
 }
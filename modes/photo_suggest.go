@@ -0,0 +1,95 @@
+package modes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"duarteocarmo/ambrosio/geo"
+	"duarteocarmo/ambrosio/logging"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// SuggestCommand asks for suggestions, which take a vision model call, so they
+	// are only made on request.
+	SuggestCommand = "suggest"
+	SuggestionUse  = "use"
+	SuggestionEdit = "edit"
+
+	CaptionPrompt = "Suggest a short caption, at most ten words, for this photo in a personal photo gallery. Reply with the caption only, without quotes."
+)
+
+type photoSuggestions struct {
+	Caption  string
+	Location string
+}
+
+// suggestForPhoto drafts a caption with a vision model and names the place in the
+// EXIF GPS data. Suggestions that cannot be made are left empty.
+func suggestForPhoto(ctx context.Context, image []byte, mimeType string) photoSuggestions {
+	logger := logging.FromContext(ctx)
+	suggestions := photoSuggestions{}
+
+	caption, err := makeChatRequest(ctx, []Message{{Role: "user", Content: ImageContent(CaptionPrompt, image, mimeType)}}, nil)
+	if err != nil {
+		logger.Warn("Could not suggest a caption", "error", err)
+	} else {
+		suggestions.Caption = strings.Trim(strings.TrimSpace(caption.Content.String()), `"`)
+	}
+
+	suggestions.Location, err = suggestLocation(ctx, image)
+	if err != nil && !errors.Is(err, geo.ErrNoGPS) {
+		logger.Warn("Could not suggest a location", "error", err)
+	}
+
+	return suggestions
+}
+
+func suggestLocation(ctx context.Context, image []byte) (string, error) {
+	coordinates, err := geo.GPSFromJPEG(image)
+	if err != nil {
+		return "", err
+	}
+
+	geocoder, err := geo.NewGeocoder()
+	if err != nil {
+		return "", err
+	}
+	return geocoder.Reverse(ctx, coordinates)
+}
+
+// askWithSuggestion starts step and sends prompt, with buttons to use or edit suggestion
+// if there is one, or else to ask for suggestions when canSuggest, to skip the step
+// and to exit.
+func askWithSuggestion(ctx context.Context, f *flowSession, step, prompt, suggestion string, canSuggest bool) {
+	f.start(step)
+	var suggestionRow []tgbotapi.InlineKeyboardButton
+	switch {
+	case suggestion != "":
+		prompt = fmt.Sprintf("%s\n\nSuggestion: %s", prompt, suggestion)
+		suggestionRow = f.actions(SuggestionUse, SuggestionEdit)
+	case canSuggest:
+		suggestionRow = f.actions(SuggestCommand)
+	}
+	f.send(ctx, prompt, suggestionRow, f.actions(SkipCommand, ExitCommand))
+}
+
+// suggestionReply turns a press on the use button into the message sending the
+// suggestion, and sends the suggestion back for editing on an edit press. It reports
+// false when the step should wait for the next input.
+func suggestionReply(ctx context.Context, f *flowSession, update tgbotapi.Update, action, suggestion string) (tgbotapi.Update, bool) {
+	switch action {
+	case SuggestionUse:
+		update.Message.Text = suggestion
+		return update, true
+	case SuggestionEdit:
+		f.send(ctx, "Edit this and send it back:")
+		f.send(ctx, suggestion)
+		return update, false
+	default:
+		return update, true
+	}
+}
@@ -0,0 +1,196 @@
+package modes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"duarteocarmo/ambrosio/model/fakellm"
+	"duarteocarmo/ambrosio/storage"
+	"duarteocarmo/ambrosio/telegram/telegramtest"
+)
+
+// runFlow runs flow in the background and returns a function waiting for its result.
+func runFlow(t *testing.T, flow func() error) func() error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- flow() }()
+
+	return func() error {
+		t.Helper()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(telegramtest.DefaultTimeout):
+			t.Fatal("flow did not finish")
+			return nil
+		}
+	}
+}
+
+// newPhotoBot returns a fake chat whose "photo-1" file downloads as a small image, with
+// the model answering from a fakellm server.
+func newPhotoBot(t *testing.T) (*telegramtest.Fake, *fakellm.Handler) {
+	t.Helper()
+
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("\xff\xd8\xff\xd9"))
+	}))
+	t.Cleanup(files.Close)
+
+	llm, handler := fakellm.NewServer()
+	t.Cleanup(llm.Close)
+	t.Setenv("TOGETHER_BASE_URL", llm.URL)
+	t.Setenv("TOGETHER_API_KEY", "test")
+
+	bot := telegramtest.NewFake(1, "owner")
+	bot.FileBaseURL = files.URL
+	bot.AddFile("photo-1", "photos/1.jpg")
+	return bot, handler
+}
+
+func TestCreatePhotoFlow(t *testing.T) {
+	bot, llm := newPhotoBot(t)
+	llm.Reply("Sunset over the river")
+
+	var uploaded storage.Photo
+	uploadPhoto = func(p *storage.Photo, ctx context.Context) (string, error) {
+		uploaded = *p
+		return "Photo created", nil
+	}
+	t.Cleanup(func() { uploadPhoto = (*storage.Photo).Create })
+
+	wait := runFlow(t, func() error { return createPhotoFlow(context.Background(), bot, bot.ChatID) })
+
+	bot.Expect(t, "Please send a photo")
+	bot.PushText("hello")
+	bot.Expect(t, "That's not a photo.")
+	bot.PushPhoto("photo-1", "")
+	bot.Expect(t, "Photo received successfully.")
+
+	bot.Expect(t, "Please send a caption")
+	bot.Press(t, actionLabels[SuggestCommand])
+	bot.Expect(t, "Suggestion: Sunset over the river")
+	bot.Press(t, actionLabels[SuggestionUse])
+	bot.Expect(t, "Caption received successfully: Sunset over the river")
+
+	bot.Expect(t, "Waiting to receive location")
+	bot.PushVenue("Lisbon")
+	bot.Expect(t, "Location received successfully: Lisbon")
+	bot.Expect(t, "Photo created")
+
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if uploaded.Caption == nil || *uploaded.Caption != "Sunset over the river" {
+		t.Errorf("caption = %v, want the suggestion", uploaded.Caption)
+	}
+	if uploaded.Location == nil || *uploaded.Location != "Lisbon" {
+		t.Errorf("location = %v, want Lisbon", uploaded.Location)
+	}
+	if want := bot.FileBaseURL + "/photos/1.jpg"; uploaded.Url != want {
+		t.Errorf("url = %q, want %q", uploaded.Url, want)
+	}
+	if uploaded.Data == nil {
+		t.Error("the upload downloads the photo again")
+	}
+}
+
+func TestCreatePhotoFlowSkipsAndExits(t *testing.T) {
+	bot, llm := newPhotoBot(t)
+
+	var uploaded *storage.Photo
+	uploadPhoto = func(p *storage.Photo, ctx context.Context) (string, error) {
+		uploaded = p
+		return "Photo created", nil
+	}
+	t.Cleanup(func() { uploadPhoto = (*storage.Photo).Create })
+
+	wait := runFlow(t, func() error { return createPhotoFlow(context.Background(), bot, bot.ChatID) })
+	bot.Expect(t, "Please send a photo")
+	bot.PushPhoto("photo-1", "")
+	bot.Expect(t, "Please send a caption")
+	bot.Press(t, actionLabels[SkipCommand])
+	bot.Expect(t, "Caption will be empty.")
+	bot.Expect(t, "Waiting to receive location")
+	bot.Press(t, actionLabels[ExitCommand])
+	bot.Expect(t, "Aborting")
+
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if uploaded != nil {
+		t.Errorf("uploaded %+v after exiting", uploaded)
+	}
+	if n := len(llm.Requests()); n != 0 {
+		t.Errorf("asked the model %d times without a request for suggestions", n)
+	}
+}
+
+func TestDeletePhotoFlow(t *testing.T) {
+	var deleted []string
+	deletePhoto = func(ctx context.Context, id string) (string, error) {
+		deleted = append(deleted, id)
+		return "Photo " + id + " deleted", nil
+	}
+	t.Cleanup(func() { deletePhoto = storage.DeletePhoto })
+
+	t.Run("confirmed", func(t *testing.T) {
+		deleted = nil
+		bot := telegramtest.NewFake(1, "owner")
+		wait := runFlow(t, func() error { return deletePhotoFlow(context.Background(), bot, bot.ChatID) })
+
+		bot.Expect(t, "Please send the photo ID to delete")
+		bot.PushText("abc123")
+		bot.Expect(t, "Delete photo abc123?")
+		bot.Press(t, actionLabels[ConfirmCommand])
+		bot.Expect(t, "Photo abc123 deleted")
+
+		if err := wait(); err != nil {
+			t.Fatal(err)
+		}
+		if len(deleted) != 1 || deleted[0] != "abc123" {
+			t.Errorf("deleted %q, want [abc123]", deleted)
+		}
+	})
+
+	t.Run("declined", func(t *testing.T) {
+		deleted = nil
+		bot := telegramtest.NewFake(1, "owner")
+		wait := runFlow(t, func() error { return deletePhotoFlow(context.Background(), bot, bot.ChatID) })
+
+		bot.Expect(t, "Please send the photo ID to delete")
+		bot.PushText("abc123")
+		bot.Expect(t, "Delete photo abc123?")
+		bot.PushText("no")
+		bot.Expect(t, "Aborting")
+
+		if err := wait(); err != nil {
+			t.Fatal(err)
+		}
+		if len(deleted) != 0 {
+			t.Errorf("deleted %q after declining", deleted)
+		}
+	})
+
+	t.Run("stale button", func(t *testing.T) {
+		deleted = nil
+		bot := telegramtest.NewFake(1, "owner")
+		wait := runFlow(t, func() error { return deletePhotoFlow(context.Background(), bot, bot.ChatID) })
+
+		bot.Expect(t, "Please send the photo ID to delete")
+		bot.PushText("abc123")
+		bot.Expect(t, "Delete photo abc123?")
+		// a button of an earlier flow is answered as expired, not taken as an exit
+		bot.PushButton(ButtonData{Kind: ButtonFlow, Session: "old", Args: []string{"id", ExitCommand}}.String())
+		bot.PushText("yes")
+		bot.Expect(t, "Photo abc123 deleted")
+
+		if err := wait(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
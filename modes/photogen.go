@@ -0,0 +1,364 @@
+package modes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"duarteocarmo/ambrosio/logging"
+	"duarteocarmo/ambrosio/model"
+	"duarteocarmo/ambrosio/storage"
+	"duarteocarmo/ambrosio/telegram"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/image/draw"
+)
+
+const (
+	HistoryCommand = "history"
+
+	GenVariations = "var"
+	GenSameSeed   = "seed"
+	GenEnlarge    = "big"
+	GenSave       = "save"
+
+	// EnlargeFactor is how much the enlarge buttons resize an image. It only resamples the
+	// pixels, adding no detail.
+	EnlargeFactor = 2
+	// MaxKeptGenerations is how many recent generations keep their images, for the
+	// buttons under them. Older ones keep their prompt and options for the history.
+	MaxKeptGenerations = 5
+
+	// MaskCaption marks a photo as the inpainting mask for the photo sent before it.
+	MaskCaption = "mask"
+)
+
+// generation is one image generation request made during a session.
+type generation struct {
+	ImageRequest
+	Images [][]byte
+	// Count and FromPhoto outlive Images and InitImage once they are dropped.
+	Count     int
+	FromPhoto bool
+}
+
+// kept reports whether the images needed for action are still there.
+func (g generation) kept(action string) bool {
+	switch action {
+	case GenEnlarge, GenSave:
+		return g.Images != nil
+	case GenVariations:
+		return !g.FromPhoto || g.InitImage != nil
+	default:
+		return true
+	}
+}
+
+// photogenSession is the state of a photogen flow, which runs until the user exits.
+type photogenSession struct {
+	flow        *flowSession
+	bot         telegram.Messenger
+	chatID      int64
+	defaults    genOptions
+	history     []generation
+	pendingSeed *int
+	// initImage and mask are used by the next prompt, for image-to-image and inpainting.
+	initImage []byte
+	mask      []byte
+}
+
+func photogenFlow(ctx context.Context, bot telegram.Messenger, chatID int64) error {
+
+	session := &photogenSession{
+		flow:     newFlowSession(bot, chatID),
+		bot:      bot,
+		chatID:   chatID,
+		defaults: defaultGenOptions(),
+	}
+	session.flow.accept(ButtonGen)
+
+	f := session.flow
+	f.send(ctx, "Photo generation mode activated. Go ahead and send your prompt, optionally with --n, --seed, --size, --steps and --neg. Send a photo to transform it, with the prompt as caption or in the next message. Use 'set <options>' to change the defaults.", f.actions(SettingsCommand, HistoryCommand, ExitCommand))
+
+	for {
+		update, _, err := f.next()
+		if err != nil {
+			return err
+		}
+
+		if update.CallbackQuery != nil {
+			session.handleButton(ctx, update.CallbackQuery)
+			continue
+		}
+
+		text := strings.TrimSpace(update.Message.Text)
+		switch {
+
+		case strings.ToLower(text) == ExitCommand:
+			sendMessage(ctx, update, bot, "Photo generation mode deactivated.")
+			return nil
+
+		case strings.ToLower(text) == SettingsCommand:
+			sendMessage(ctx, update, bot, "Current settings: "+session.defaults.String())
+
+		case strings.ToLower(text) == HistoryCommand:
+			sendMessage(ctx, update, bot, session.describeHistory())
+
+		case strings.HasPrefix(strings.ToLower(text), SetCommand+" --"):
+			_, opts, err := parseGenOptions(text[len(SetCommand)+1:], session.defaults)
+			if err == nil {
+				err = opts.validate(PhotoGenModelID)
+			}
+			if err != nil {
+				sendMessage(ctx, update, bot, fmt.Sprintf("Invalid settings: %v", err))
+				continue
+			}
+			session.defaults = opts
+			sendMessage(ctx, update, bot, "Settings updated: "+session.defaults.String())
+
+		case photoFileID(update.Message) != "":
+			session.receivePhoto(ctx, update)
+
+		case text != "":
+			session.prompt(ctx, update, text)
+
+		default:
+			sendMessage(ctx, update, bot, "That's not a text generation message, send a prompt or 'exit'.")
+		}
+	}
+}
+
+// receivePhoto keeps a photo to start the next generation from, or its mask, and
+// generates right away when the photo comes with a prompt.
+func (s *photogenSession) receivePhoto(ctx context.Context, update tgbotapi.Update) {
+	s.bot.Send(tgbotapi.NewChatAction(s.chatID, "typing"))
+	data, err := downloadFile(ctx, s.bot, photoFileID(update.Message))
+	if err != nil {
+		logging.FromContext(ctx).Error("Error downloading photo", "error", err)
+		sendMessage(ctx, update, s.bot, fmt.Sprintf("Could not download the photo: %v", err))
+		return
+	}
+
+	caption := strings.TrimSpace(update.Message.Caption)
+	switch {
+	case strings.ToLower(caption) == MaskCaption:
+		if s.initImage == nil {
+			sendMessage(ctx, update, s.bot, "Send the photo to repaint before its mask.")
+			return
+		}
+		s.mask = data
+		sendMessage(ctx, update, s.bot, "Mask received, now send the prompt for the white areas.")
+
+	case caption != "":
+		s.initImage, s.mask = data, nil
+		s.prompt(ctx, update, caption)
+
+	default:
+		s.initImage, s.mask = data, nil
+		sendMessage(ctx, update, s.bot, "Photo received. Send the prompt to transform it, with --strength between 0 and 1 for how much it changes, or first a black and white photo with caption 'mask' to only repaint its white areas.")
+	}
+}
+
+// prompt generates images for text, starting from the photo received before it if any.
+func (s *photogenSession) prompt(ctx context.Context, update tgbotapi.Update, text string) {
+	genText, opts, err := parseGenOptions(text, s.defaults)
+	if err == nil {
+		err = opts.validate(PhotoGenModelID)
+	}
+	if err == nil && genText == "" {
+		err = fmt.Errorf("the prompt is empty")
+	}
+	if err != nil {
+		sendMessage(ctx, update, s.bot, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+
+	if s.pendingSeed != nil {
+		opts.Seed, opts.RandomSeed = *s.pendingSeed, false
+		s.pendingSeed = nil
+	}
+
+	req := ImageRequest{Prompt: genText, Opts: opts, InitImage: s.initImage, Mask: s.mask}
+	s.initImage, s.mask = nil, nil
+	s.generate(ctx, req)
+}
+
+// generate runs a generation, sends the images and the buttons to act on them.
+func (s *photogenSession) generate(ctx context.Context, req ImageRequest) {
+	req.Opts = req.Opts.withSeed()
+	prompt, opts := req.Prompt, req.Opts
+	status := fmt.Sprintf("Generating photo for text: %s (%s)", prompt, opts)
+	switch {
+	case req.Mask != nil:
+		status += ", repainting the masked photo"
+	case req.InitImage != nil:
+		status += ", starting from your photo"
+	}
+	s.bot.Send(tgbotapi.NewMessage(s.chatID, status))
+	s.bot.Send(tgbotapi.NewChatAction(s.chatID, "upload_photo"))
+
+	images, err := imageProvider.Generate(ctx, req)
+	if err != nil {
+		logging.FromContext(ctx).Error("Error generating photo", "error", err)
+		s.bot.Send(tgbotapi.NewMessage(s.chatID, model.UserMessage(err)))
+		return
+	}
+
+	s.history = append(s.history, generation{ImageRequest: req, Images: images, Count: len(images), FromPhoto: req.InitImage != nil})
+	index := len(s.history) - 1
+	if old := index - MaxKeptGenerations; old >= 0 {
+		s.history[old].Images, s.history[old].InitImage, s.history[old].Mask = nil, nil, nil
+	}
+
+	mediaGroup := []interface{}{}
+	for i, img := range images {
+		mediaGroup = append(mediaGroup, tgbotapi.NewInputMediaPhoto(tgbotapi.FileBytes{Name: "image" + fmt.Sprintf("%d", i) + ".png", Bytes: img}))
+	}
+	if _, err := s.bot.SendMediaGroup(tgbotapi.NewMediaGroup(s.chatID, mediaGroup)); err != nil {
+		logging.FromContext(ctx).Error("Error sending generated photos", "error", err)
+	}
+
+	msg := tgbotapi.NewMessage(s.chatID, fmt.Sprintf("#%d seed %d. What next? Use the buttons for variations, enlarging 2x or saving to the gallery.", index+1, opts.Seed))
+	msg.ReplyMarkup = s.keyboard(index, len(images))
+	s.bot.Send(msg)
+}
+
+func (s *photogenSession) keyboard(index, images int) tgbotapi.InlineKeyboardMarkup {
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(
+			s.button("🔁 Variations", index, GenVariations, 0),
+			s.button("🎲 Same seed, new prompt", index, GenSameSeed, 0),
+		),
+	}
+
+	enlarge := []tgbotapi.InlineKeyboardButton{}
+	for i := 0; i < images; i++ {
+		enlarge = append(enlarge, s.button(fmt.Sprintf("🔍 Enlarge %d 2x", i+1), index, GenEnlarge, i))
+	}
+	save := []tgbotapi.InlineKeyboardButton{}
+	for i := 0; i < images; i++ {
+		save = append(save, s.button(fmt.Sprintf("💾 Save %d", i+1), index, GenSave, i))
+	}
+	rows = append(rows, enlarge, save)
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (s *photogenSession) button(text string, index int, action string, image int) tgbotapi.InlineKeyboardButton {
+	return s.flow.button(text, ButtonGen, strconv.Itoa(index), action, strconv.Itoa(image))
+}
+
+// handleButton acts on a press of the buttons sent under a generation. The flow
+// session only passes on buttons of this session.
+func (s *photogenSession) handleButton(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	data, _ := ParseButtonData(query.Data)
+	if data.Kind != ButtonGen || len(data.Args) != 3 {
+		s.bot.Request(tgbotapi.NewCallback(query.ID, ExpiredButton))
+		return
+	}
+
+	index, err := strconv.Atoi(data.Arg(0))
+	if err != nil || index < 0 || index >= len(s.history) {
+		s.bot.Request(tgbotapi.NewCallback(query.ID, ExpiredButton))
+		return
+	}
+	imageIndex, err := strconv.Atoi(data.Arg(2))
+	gen := s.history[index]
+	if err != nil || imageIndex < 0 || imageIndex >= gen.Count {
+		s.bot.Request(tgbotapi.NewCallback(query.ID, ExpiredButton))
+		return
+	}
+
+	s.bot.Request(tgbotapi.NewCallback(query.ID, ""))
+
+	action := data.Arg(1)
+	if !gen.kept(action) {
+		s.bot.Send(tgbotapi.NewMessage(s.chatID, fmt.Sprintf("#%d is too old, only the images of the last %d generations are kept.", index+1, MaxKeptGenerations)))
+		return
+	}
+
+	switch action {
+	case GenVariations:
+		req := gen.ImageRequest
+		req.Opts.RandomSeed = true
+		s.generate(ctx, req)
+
+	case GenSameSeed:
+		seed := gen.Opts.Seed
+		s.pendingSeed = &seed
+		s.bot.Send(tgbotapi.NewMessage(s.chatID, fmt.Sprintf("Send the new prompt, it will use seed %d.", seed)))
+
+	case GenEnlarge:
+		s.bot.Send(tgbotapi.NewChatAction(s.chatID, "upload_document"))
+		enlarged, err := enlargeImage(gen.Images[imageIndex], EnlargeFactor)
+		if err != nil {
+			logging.FromContext(ctx).Error("Error enlarging photo", "error", err)
+			s.bot.Send(tgbotapi.NewMessage(s.chatID, fmt.Sprintf("Could not enlarge the photo: %v", err)))
+			return
+		}
+		doc := tgbotapi.NewDocument(s.chatID, tgbotapi.FileBytes{Name: fmt.Sprintf("image%d-%dx.png", imageIndex, EnlargeFactor), Bytes: enlarged})
+		doc.Caption = fmt.Sprintf("#%d image %d enlarged %dx, resized without adding detail", index+1, imageIndex+1, EnlargeFactor)
+		if _, err := s.bot.Send(doc); err != nil {
+			logging.FromContext(ctx).Error("Error sending enlarged photo", "error", err)
+		}
+
+	case GenSave:
+		s.bot.Send(tgbotapi.NewChatAction(s.chatID, "typing"))
+		prompt := gen.Prompt
+		p := storage.Photo{
+			Data:    gen.Images[imageIndex],
+			Caption: &prompt,
+			Generation: &storage.Generation{
+				Prompt: gen.Prompt,
+				Model:  PhotoGenModelID,
+				Seed:   gen.Opts.Seed,
+			},
+		}
+		msg, err := uploadPhoto(&p, ctx)
+		if err != nil {
+			logging.FromContext(ctx).Error("Error saving generated photo", "error", err)
+			s.bot.Send(tgbotapi.NewMessage(s.chatID, fmt.Sprintf("Could not save the photo: %v", err)))
+			return
+		}
+		s.bot.Send(tgbotapi.NewMessage(s.chatID, msg))
+	}
+}
+
+func (s *photogenSession) describeHistory() string {
+	if len(s.history) == 0 {
+		return "Nothing generated yet."
+	}
+
+	var lines []string
+	for i, gen := range s.history {
+		line := fmt.Sprintf("#%d %s (%s)", i+1, gen.Prompt, gen.Opts)
+		if gen.FromPhoto {
+			line += " from a photo"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// enlargeImage resizes a PNG by factor with Catmull-Rom resampling, which smooths the
+// pixels but adds no detail.
+func enlargeImage(data []byte, factor int) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*factor, bounds.Dy()*factor))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
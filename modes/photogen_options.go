@@ -0,0 +1,183 @@
+package modes
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+const (
+	SetCommand      = "set"
+	SettingsCommand = "settings"
+	RandomSeed      = "random"
+)
+
+// imageModelLimits bounds the options accepted for an image model.
+type imageModelLimits struct {
+	MaxN         int
+	MinSize      int
+	MaxSize      int
+	SizeMultiple int
+	MaxSteps     int
+}
+
+var imageLimits = map[string]imageModelLimits{
+	PhotoGenModelID: {MaxN: 4, MinSize: 256, MaxSize: 1536, SizeMultiple: 64, MaxSteps: 50},
+}
+
+// genOptions are the parameters of an image generation request.
+type genOptions struct {
+	N              int
+	Width          int
+	Height         int
+	Steps          int
+	Seed           int
+	RandomSeed     bool
+	NegativePrompt string
+	// Strength is how much an initial image is changed, from 0 to 1.
+	Strength float64
+}
+
+func defaultGenOptions() genOptions {
+	return genOptions{N: 4, Width: 1024, Height: 1024, Steps: 40, RandomSeed: true, Strength: 0.6}
+}
+
+func (o genOptions) String() string {
+	seed := RandomSeed
+	if !o.RandomSeed {
+		seed = strconv.Itoa(o.Seed)
+	}
+	s := fmt.Sprintf("--n %d --size %dx%d --steps %d --seed %s --strength %g", o.N, o.Width, o.Height, o.Steps, seed, o.Strength)
+	if o.NegativePrompt != "" {
+		s += fmt.Sprintf(" --neg %q", o.NegativePrompt)
+	}
+	return s
+}
+
+// withSeed returns the options with a concrete seed, drawing one if the seed is random.
+func (o genOptions) withSeed() genOptions {
+	if o.RandomSeed {
+		o.Seed = rand.Intn(1 << 31)
+		o.RandomSeed = false
+	}
+	return o
+}
+
+func (o genOptions) validate(modelID string) error {
+	limits, ok := imageLimits[modelID]
+	if !ok {
+		return nil
+	}
+
+	if o.N < 1 || o.N > limits.MaxN {
+		return fmt.Errorf("--n must be between 1 and %d", limits.MaxN)
+	}
+	if o.Steps < 1 || o.Steps > limits.MaxSteps {
+		return fmt.Errorf("--steps must be between 1 and %d", limits.MaxSteps)
+	}
+	for _, size := range []int{o.Width, o.Height} {
+		if size < limits.MinSize || size > limits.MaxSize || size%limits.SizeMultiple != 0 {
+			return fmt.Errorf("--size sides must be multiples of %d between %d and %d", limits.SizeMultiple, limits.MinSize, limits.MaxSize)
+		}
+	}
+	if o.Strength <= 0 || o.Strength > 1 {
+		return fmt.Errorf("--strength must be above 0 and at most 1")
+	}
+	if o.Seed < 0 {
+		return fmt.Errorf("--seed must be a positive number or %s", RandomSeed)
+	}
+	return nil
+}
+
+// parseGenOptions splits text into a prompt and the options given with it, such as
+// `a cat --n 2 --seed random --size 768x1344 --steps 30 --strength 0.5 --neg "blurry"`,
+// starting from base for options that are not given.
+func parseGenOptions(text string, base genOptions) (string, genOptions, error) {
+	args, err := splitArgs(text)
+	if err != nil {
+		return "", base, err
+	}
+
+	opts := base
+	var prompt []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") {
+			prompt = append(prompt, arg)
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return "", base, fmt.Errorf("%s needs a value", arg)
+		}
+		i++
+		value := args[i]
+
+		switch arg {
+		case "--n":
+			opts.N, err = strconv.Atoi(value)
+		case "--steps":
+			opts.Steps, err = strconv.Atoi(value)
+		case "--seed":
+			if value == RandomSeed {
+				opts.RandomSeed = true
+				break
+			}
+			opts.RandomSeed = false
+			opts.Seed, err = strconv.Atoi(value)
+		case "--size":
+			width, height, found := strings.Cut(value, "x")
+			if !found {
+				return "", base, fmt.Errorf("--size must look like 768x1344")
+			}
+			if opts.Width, err = strconv.Atoi(width); err == nil {
+				opts.Height, err = strconv.Atoi(height)
+			}
+		case "--neg":
+			opts.NegativePrompt = value
+		case "--strength":
+			opts.Strength, err = strconv.ParseFloat(value, 64)
+		default:
+			return "", base, fmt.Errorf("unknown option %s, use --n, --seed, --size, --steps, --strength or --neg", arg)
+		}
+		if err != nil {
+			return "", base, fmt.Errorf("invalid value %q for %s", value, arg)
+		}
+	}
+
+	return strings.Join(prompt, " "), opts, nil
+}
+
+// splitArgs splits text on whitespace, keeping double-quoted parts together.
+func splitArgs(text string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inQuotes, inArg := false, false
+
+	for _, r := range text {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			inArg = true
+		case !inQuotes && (r == ' ' || r == '\t' || r == '\n'):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,169 @@
+package modes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"duarteocarmo/ambrosio/logging"
+	"duarteocarmo/ambrosio/reminders"
+	"duarteocarmo/ambrosio/telegram"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	ReminderSnooze = "snooze"
+	ReminderCancel = "cancel"
+	ReminderDone   = "done"
+)
+
+// RemindCommand handles /remind <when> <what>.
+func RemindCommand(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, store *reminders.Store) error {
+	chatID := update.Message.Chat.ID
+	args := strings.TrimSpace(update.Message.CommandArguments())
+	if args == "" {
+		sendMessage(ctx, update, bot, reminders.Usage)
+		return nil
+	}
+
+	loc := store.Location(chatID)
+	r, err := reminders.Parse(args, time.Now(), loc)
+	if err != nil {
+		sendMessage(ctx, update, bot, fmt.Sprintf("I couldn't understand that: %v.\n\n%s", err, reminders.Usage))
+		return nil
+	}
+
+	r.ChatID = chatID
+	r, err = store.Add(r)
+	if err != nil {
+		return fmt.Errorf("error saving reminder: %w", err)
+	}
+
+	logging.FromContext(ctx).Info("Added reminder", "reminder_id", r.ID, "due", r.Due)
+	sendMessage(ctx, update, bot, fmt.Sprintf("⏰ I'll remind you %s (%s): %s", r.Schedule(loc), loc, r.Text))
+	return nil
+}
+
+// RemindersCommand handles /reminders, listing pending reminders with buttons to cancel
+// them or snooze one-off ones. Repeating ones are snoozed when they are sent.
+func RemindersCommand(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, store *reminders.Store) error {
+	chatID := update.Message.Chat.ID
+	list := store.List(chatID)
+	if len(list) == 0 {
+		sendMessage(ctx, update, bot, "You have no reminders. Set one with /remind.")
+		return nil
+	}
+
+	loc := store.Location(chatID)
+	lines := []string{"Your reminders:"}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, r := range list {
+		lines = append(lines, fmt.Sprintf("%d. %s: %s", i+1, r.Schedule(loc), r.Text))
+		row := tgbotapi.NewInlineKeyboardRow(reminderButton(fmt.Sprintf("❌ Cancel %d", i+1), r.ID, ReminderCancel, ""))
+		if !r.Repeats() {
+			row = append([]tgbotapi.InlineKeyboardButton{reminderButton(fmt.Sprintf("⏰ %d +1h", i+1), r.ID, ReminderSnooze, "1h")}, row...)
+		}
+		rows = append(rows, row)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, strings.Join(lines, "\n"))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	if _, err := bot.Send(msg); err != nil {
+		return fmt.Errorf("error sending reminders: %w", err)
+	}
+	return nil
+}
+
+// TimezoneCommand handles /timezone, showing or setting the timezone reminders use.
+func TimezoneCommand(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger, store *reminders.Store) error {
+	chatID := update.Message.Chat.ID
+	name := strings.TrimSpace(update.Message.CommandArguments())
+	if name == "" {
+		sendMessage(ctx, update, bot, fmt.Sprintf("Your timezone is %s. Change it with /timezone Europe/Lisbon.", store.Location(chatID)))
+		return nil
+	}
+
+	loc, err := store.SetTimezone(chatID, name)
+	if err != nil {
+		sendMessage(ctx, update, bot, err.Error())
+		return nil
+	}
+	sendMessage(ctx, update, bot, fmt.Sprintf("Timezone set to %s, it's %s there now.", loc, time.Now().In(loc).Format("15:04")))
+	return nil
+}
+
+// SendReminder sends a due reminder with buttons to snooze it or mark it done.
+func SendReminder(bot telegram.Messenger) func(ctx context.Context, r reminders.Reminder) error {
+	return func(ctx context.Context, r reminders.Reminder) error {
+		msg := tgbotapi.NewMessage(r.ChatID, "⏰ "+r.Text)
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			reminderButton("+10m", r.ID, ReminderSnooze, "10m"),
+			reminderButton("+1h", r.ID, ReminderSnooze, "1h"),
+			reminderButton("+1d", r.ID, ReminderSnooze, "24h"),
+			reminderButton("✅ Done", r.ID, ReminderDone, ""),
+		))
+		_, err := bot.Send(msg)
+		return err
+	}
+}
+
+// ReminderButton handles presses of the buttons sent with reminders and /reminders.
+func ReminderButton(ctx context.Context, query *tgbotapi.CallbackQuery, bot telegram.Messenger, store *reminders.Store) error {
+	data, ok := ParseButtonData(query.Data)
+	if !ok || data.Kind != ButtonReminder || len(data.Args) != 3 {
+		bot.Request(tgbotapi.NewCallback(query.ID, ExpiredButton))
+		return nil
+	}
+	id, action, arg := data.Arg(0), data.Arg(1), data.Arg(2)
+
+	var answer string
+	var err error
+	switch action {
+	case ReminderSnooze:
+		var d time.Duration
+		d, err = time.ParseDuration(arg)
+		if err != nil {
+			break
+		}
+		var r reminders.Reminder
+		r, err = store.Snooze(id, d, time.Now())
+		if err == nil {
+			answer = "Snoozed until " + r.Schedule(store.Location(r.ChatID))
+		}
+	case ReminderCancel:
+		err = store.Remove(id)
+		answer = "Reminder cancelled"
+	case ReminderDone:
+		r, getErr := store.Get(id)
+		if getErr == nil && !r.Repeats() {
+			err = store.Remove(id)
+		}
+		answer = "Done"
+	default:
+		err = reminders.ErrNotFound
+	}
+
+	if errors.Is(err, reminders.ErrNotFound) {
+		bot.Request(tgbotapi.NewCallback(query.ID, "This reminder no longer exists."))
+		return nil
+	}
+	if err != nil {
+		bot.Request(tgbotapi.NewCallback(query.ID, "Something went wrong."))
+		return fmt.Errorf("error handling reminder button: %w", err)
+	}
+
+	bot.Request(tgbotapi.NewCallback(query.ID, answer))
+	if action != ReminderCancel {
+		RemoveKeyboard(bot, query)
+	}
+	return nil
+}
+
+// reminderButton acts on reminder id. It has no session, so it works for as long as
+// the reminder exists.
+func reminderButton(text, id, action, arg string) tgbotapi.InlineKeyboardButton {
+	return newButton(text, ButtonData{Kind: ButtonReminder, Args: []string{id, action, arg}})
+}
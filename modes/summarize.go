@@ -0,0 +1,97 @@
+package modes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"duarteocarmo/ambrosio/logging"
+	"duarteocarmo/ambrosio/model"
+	"duarteocarmo/ambrosio/telegram"
+	"duarteocarmo/ambrosio/web"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// MaxURLsPerMessage is how many links of a chat message are read.
+const MaxURLsPerMessage = 3
+
+// pageFetcher is shared so pages fetched by /summarize and chat mode are cached once.
+var pageFetcher = web.NewFetcher()
+
+// SummarizeCommand handles /summarize <url>.
+func SummarizeCommand(ctx context.Context, update tgbotapi.Update, bot telegram.Messenger) error {
+	chatID := update.Message.Chat.ID
+	urls := web.FindURLs(update.Message.CommandArguments())
+	if len(urls) == 0 {
+		sendMessage(ctx, update, bot, "Send /summarize <url>, or send links in /assistant chat to ask about them.")
+		return nil
+	}
+
+	for _, url := range urls[:min(len(urls), MaxURLsPerMessage)] {
+		bot.Send(tgbotapi.NewChatAction(chatID, "typing"))
+		page, err := pageFetcher.Fetch(ctx, url)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Error fetching page", "url", url, "error", err)
+			sendMessage(ctx, update, bot, fmt.Sprintf("Could not read %s: %v", url, err))
+			continue
+		}
+		summary, err := summarizePage(ctx, bot, chatID, page)
+		if err != nil {
+			logging.FromContext(ctx).Error("Error summarizing page", "url", url, "error", err)
+			sendMessage(ctx, update, bot, model.UserMessage(err))
+			continue
+		}
+		sendMessage(ctx, update, bot, fmt.Sprintf("%s\n\n%s", page.Title, summary))
+	}
+	return nil
+}
+
+// summarizePage summarises a page, part by part when it is long.
+func summarizePage(ctx context.Context, bot telegram.Messenger, chatID int64, page web.Page) (string, error) {
+	if len(page.Text) > StuffLimit {
+		return summariseDocument(ctx, bot, chatID, page.Title, page.Text)
+	}
+
+	answer, err := makeChatRequest(ctx, []Message{{Role: "user", Content: TextContent(pagePrompt(page))}}, nil)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(answer.Content.String()), nil
+}
+
+func pagePrompt(page web.Page) string {
+	return fmt.Sprintf("Summarise the page %q (%s) in a few bullet points, keeping names, numbers and conclusions.\n\n%s", page.Title, page.URL, page.Text)
+}
+
+// readPage summarises a page linked in chat mode and returns the conversation about it,
+// with the whole page when it is short enough and its summary otherwise. Long pages
+// are indexed in kb, the conversation's own index, so questions can retrieve the
+// relevant parts without the page reaching ask mode.
+func readPage(ctx context.Context, bot telegram.Messenger, chatID int64, page web.Page, kb *Knowledge) (documentContext, error) {
+	summary, err := summarizePage(ctx, bot, chatID, page)
+	if err != nil {
+		return documentContext{}, err
+	}
+
+	dc := documentContext{Name: page.Title}
+	question := pagePrompt(page)
+
+	if len(page.Text) > StuffLimit {
+		question = fmt.Sprintf("Summarise the page %q (%s), which is too long to read whole. Relevant parts will come with my questions.", page.Title, page.URL)
+		if kb != nil && kb.Index != nil {
+			source := fmt.Sprintf("page %s #", page.URL)
+			if err := indexDocument(ctx, kb, source, page.Text); err != nil {
+				logging.FromContext(ctx).Warn("Could not index page, answering from its summary", "error", err)
+			} else {
+				dc.Source = source
+			}
+		}
+	}
+
+	dc.Messages = []Message{
+		{Role: "user", Content: TextContent(question)},
+		{Role: "assistant", Content: TextContent(summary)},
+	}
+	return dc, nil
+}
@@ -0,0 +1,250 @@
+package modes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"duarteocarmo/ambrosio/logging"
+	"duarteocarmo/ambrosio/storage"
+	"duarteocarmo/ambrosio/telegram"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// MaxToolRounds bounds how many times the model can call tools before answering.
+	MaxToolRounds = 5
+
+	ToolConfirm = "yes"
+	ToolDecline = "no"
+)
+
+// Tool is a Go function the model can call during a chat.
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters is the JSON schema of the arguments.
+	Parameters map[string]interface{}
+	// Confirm marks tools with side effects, which only run after the user agrees.
+	Confirm bool
+	Run     func(ctx context.Context, args json.RawMessage) (string, error)
+}
+
+// ToolCall is a call the model asks for, with JSON encoded arguments.
+type ToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type ApiTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string                 `json:"name"`
+		Description string                 `json:"description"`
+		Parameters  map[string]interface{} `json:"parameters"`
+	} `json:"function"`
+}
+
+// chatTools are the tools offered in chat mode.
+var chatTools = []Tool{
+	{
+		Name:        "list_photos",
+		Description: "List the most recent photos in the photo gallery, with their ID, date, caption and location.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"limit": map[string]interface{}{"type": "integer", "description": "How many photos to list, 10 by default."},
+			},
+		},
+		Run: listPhotosTool,
+	},
+	{
+		Name:        "delete_photo",
+		Description: "Delete a photo from the photo gallery by its ID.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"id": map[string]interface{}{"type": "string", "description": "The ID of the photo."},
+			},
+			"required": []string{"id"},
+		},
+		Confirm: true,
+		Run:     deletePhotoTool,
+	},
+}
+
+func listPhotosTool(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Limit int `json:"limit"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	if params.Limit <= 0 {
+		params.Limit = 10
+	}
+
+	photos, err := storage.ListPhotos(ctx, params.Limit)
+	if err != nil {
+		return "", err
+	}
+	if len(photos) == 0 {
+		return "The gallery is empty.", nil
+	}
+
+	var lines []string
+	for _, p := range photos {
+		line := fmt.Sprintf("ID %s, taken %s", p.ID, p.Date)
+		if p.Caption != nil {
+			line += ", caption: " + *p.Caption
+		}
+		if p.Location != nil {
+			line += ", location: " + *p.Location
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func deletePhotoTool(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	if params.ID == "" {
+		return "", fmt.Errorf("id is required")
+	}
+	return storage.DeletePhoto(ctx, params.ID)
+}
+
+func toolSpecs(tools []Tool) []ApiTool {
+	var specs []ApiTool
+	for _, tool := range tools {
+		spec := ApiTool{Type: "function"}
+		spec.Function.Name = tool.Name
+		spec.Function.Description = tool.Description
+		spec.Function.Parameters = tool.Parameters
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+func findTool(tools []Tool, name string) (Tool, bool) {
+	for _, tool := range tools {
+		if tool.Name == name {
+			return tool, true
+		}
+	}
+	return Tool{}, false
+}
+
+// runTools asks the model for an answer, running the tools it calls and feeding their
+// results back until it answers in text. It returns the answer and the conversation
+// including the tool calls and the answer.
+func runTools(ctx context.Context, bot telegram.Messenger, chatID int64, messages []Message) (Message, []Message, error) {
+	for round := 0; round < MaxToolRounds; round++ {
+		answer, err := makeChatRequest(ctx, messages, chatTools)
+		if err != nil {
+			return Message{}, messages, err
+		}
+		messages = append(messages, answer)
+
+		if len(answer.ToolCalls) == 0 {
+			return answer, messages, nil
+		}
+
+		for _, call := range answer.ToolCalls {
+			result, err := runToolCall(ctx, bot, chatID, call)
+			if err != nil {
+				return Message{}, messages, err
+			}
+			messages = append(messages, Message{Role: "tool", ToolCallID: call.ID, Name: call.Function.Name, Content: TextContent(result)})
+		}
+		bot.Send(tgbotapi.NewChatAction(chatID, "typing"))
+	}
+
+	return Message{}, messages, fmt.Errorf("the model kept calling tools after %d rounds", MaxToolRounds)
+}
+
+// runToolCall runs a tool call and returns the result for the model. Tool failures
+// are reported to the model rather than returned; the error is only set when the
+// user could not be asked for confirmation.
+func runToolCall(ctx context.Context, bot telegram.Messenger, chatID int64, call ToolCall) (string, error) {
+	logger := logging.FromContext(ctx).With("tool", call.Function.Name)
+
+	tool, ok := findTool(chatTools, call.Function.Name)
+	if !ok {
+		logger.Warn("Model called an unknown tool")
+		return fmt.Sprintf("Error: there is no tool called %s.", call.Function.Name), nil
+	}
+
+	args := json.RawMessage(call.Function.Arguments)
+	if strings.TrimSpace(call.Function.Arguments) == "" {
+		args = json.RawMessage("{}")
+	}
+
+	if tool.Confirm {
+		confirmed, err := confirmToolCall(ctx, bot, chatID, call)
+		if err != nil {
+			return "", err
+		}
+		if !confirmed {
+			logger.Info("User declined tool call")
+			return "The user declined, the tool was not run.", nil
+		}
+	}
+
+	logger.Info("Running tool", "arguments", call.Function.Arguments)
+	result, err := tool.Run(ctx, args)
+	if err != nil {
+		logger.Error("Tool failed", "error", err)
+		return fmt.Sprintf("Error: %v", err), nil
+	}
+	return result, nil
+}
+
+// confirmToolCall asks the user whether to run a tool call, with yes and no buttons.
+// Replying yes or no works too; anything else is asked again.
+func confirmToolCall(ctx context.Context, bot telegram.Messenger, chatID int64, call ToolCall) (bool, error) {
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Run %s with %s?", call.Function.Name, call.Function.Arguments))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		newButton("✅ Yes", ButtonData{Kind: ButtonTool, Session: call.ID, Args: []string{ToolConfirm}}),
+		newButton("❌ No", ButtonData{Kind: ButtonTool, Session: call.ID, Args: []string{ToolDecline}}),
+	))
+	bot.Send(msg)
+
+	for {
+		update, err := nextInput(bot.Updates(), chatID)
+		if err != nil {
+			return false, err
+		}
+
+		if query := update.CallbackQuery; query != nil {
+			data, ok := ParseButtonData(query.Data)
+			if !ok || data.Kind != ButtonTool || data.Session != call.ID {
+				bot.Request(tgbotapi.NewCallback(query.ID, ExpiredButton))
+				continue
+			}
+			bot.Request(tgbotapi.NewCallback(query.ID, ""))
+			RemoveKeyboard(bot, query)
+			return data.Arg(0) == ToolConfirm, nil
+		}
+
+		switch strings.ToLower(strings.TrimSpace(update.Message.Text)) {
+		case ToolConfirm:
+			return true, nil
+		case ToolDecline:
+			return false, nil
+		default:
+			sendMessage(ctx, update, bot, fmt.Sprintf("Should I run %s first? Press a button, or reply yes or no.", call.Function.Name))
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package modes
+
+import (
+	"errors"
+	"log/slog"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ErrShuttingDown is returned by flows that were waiting for input when the bot stopped receiving updates.
+var ErrShuttingDown = errors.New("bot is shutting down")
+
+// nextInput blocks until the next message or inline button press in chatID arrives.
+// Input from other chats is not for the flow waiting on it and is skipped.
+func nextInput(updates tgbotapi.UpdatesChannel, chatID int64) (tgbotapi.Update, error) {
+	for {
+		update, ok := <-updates
+		if !ok {
+			return tgbotapi.Update{}, ErrShuttingDown
+		}
+		if update.Message == nil && update.CallbackQuery == nil {
+			continue
+		}
+		if id, ok := updateChatID(update); !ok || id != chatID {
+			slog.Warn("Skipping input from another chat while a flow waits", "chat_id", id, "flow_chat_id", chatID)
+			continue
+		}
+		return update, nil
+	}
+}
+
+// updateChatID returns the chat of a message or button press, which inline messages
+// have none of.
+func updateChatID(update tgbotapi.Update) (int64, bool) {
+	switch {
+	case update.Message != nil:
+		return update.Message.Chat.ID, true
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		return update.CallbackQuery.Message.Chat.ID, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,92 @@
+package modes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"duarteocarmo/ambrosio/speech"
+	"duarteocarmo/ambrosio/telegram"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func isSpeech(message *tgbotapi.Message) bool {
+	return message.Voice != nil || message.Audio != nil
+}
+
+// transcribeMessage downloads the voice note or audio file in message and returns its text.
+func transcribeMessage(ctx context.Context, bot telegram.Messenger, message *tgbotapi.Message) (string, error) {
+	transcriber, err := speech.NewTranscriber()
+	if err != nil {
+		return "", err
+	}
+
+	var fileID, filename string
+	switch {
+	case message.Voice != nil:
+		fileID, filename = message.Voice.FileID, "voice.ogg"
+	case message.Audio != nil:
+		fileID, filename = message.Audio.FileID, message.Audio.FileName
+		if filename == "" {
+			filename = "audio.mp3"
+		}
+	default:
+		return "", fmt.Errorf("message has no audio")
+	}
+
+	audio, err := downloadFile(ctx, bot, fileID)
+	if err != nil {
+		return "", err
+	}
+
+	return transcriber.Transcribe(ctx, filename, audio)
+}
+
+// voiceReplies controls whether chat answers are also spoken.
+type voiceReplies int
+
+const (
+	voiceOff voiceReplies = iota
+	voiceAlongside
+	voiceOnly
+)
+
+// parseVoiceCommand understands "voice on", "voice only" and "voice off".
+func parseVoiceCommand(text string) (voiceReplies, bool) {
+	fields := strings.Fields(strings.ToLower(text))
+	if len(fields) != 2 || fields[0] != VoiceCommand {
+		return voiceOff, false
+	}
+
+	switch fields[1] {
+	case "on":
+		return voiceAlongside, true
+	case "only":
+		return voiceOnly, true
+	case "off":
+		return voiceOff, true
+	default:
+		return voiceOff, false
+	}
+}
+
+var markdownReplacer = strings.NewReplacer("*", "", "_", "", "`", "", "#", "")
+
+// sendVoiceReply speaks text as a voice note in chatID.
+func sendVoiceReply(ctx context.Context, bot telegram.Messenger, chatID int64, text string) error {
+	synthesizer, err := speech.NewSynthesizer()
+	if err != nil {
+		return err
+	}
+
+	bot.Send(tgbotapi.NewChatAction(chatID, "record_voice"))
+
+	audio, err := synthesizer.Synthesize(ctx, markdownReplacer.Replace(text))
+	if err != nil {
+		return err
+	}
+
+	_, err = bot.Send(tgbotapi.NewVoice(chatID, tgbotapi.FileBytes{Name: "reply.ogg", Bytes: audio}))
+	return err
+}
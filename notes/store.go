@@ -0,0 +1,240 @@
+// Package notes keeps quick notes and to-dos, tagged with #hashtags, in a JSON file.
+package notes
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"duarteocarmo/ambrosio/jsonfile"
+)
+
+const (
+	FileName = "notes.json"
+
+	KindNote = "note"
+	KindTodo = "todo"
+)
+
+var ErrNotFound = errors.New("note not found")
+
+// Note is a note or a to-do. IDs are small numbers, counted per store, so they are easy
+// to type in /todo done.
+type Note struct {
+	ID      int       `json:"id"`
+	ChatID  int64     `json:"chat_id"`
+	Kind    string    `json:"kind"`
+	Text    string    `json:"text"`
+	Tags    []string  `json:"tags,omitempty"`
+	Created time.Time `json:"created"`
+	Done    bool      `json:"done,omitempty"`
+	DoneAt  time.Time `json:"done_at,omitempty"`
+}
+
+// Filter selects notes in List. Empty fields match everything.
+type Filter struct {
+	Kind string
+	Tag  string
+	// WithDone includes finished to-dos.
+	WithDone bool
+}
+
+type storeFile struct {
+	NextID int    `json:"next_id"`
+	Notes  []Note `json:"notes"`
+}
+
+// Store keeps notes in a JSON file.
+type Store struct {
+	file jsonfile.File
+
+	mu   sync.Mutex
+	data storeFile
+}
+
+// NewStore loads the store from dir, creating the directory if needed.
+func NewStore(dir string) (*Store, error) {
+	file, err := jsonfile.Open(dir, FileName)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{file: file, data: storeFile{NextID: 1}}
+	if err := file.Load(&s.data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add saves a note or to-do, taking its tags from the #hashtags in text.
+func (s *Store) Add(chatID int64, kind, text string) (Note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := Note{
+		ID:      s.data.NextID,
+		ChatID:  chatID,
+		Kind:    kind,
+		Text:    text,
+		Tags:    Tags(text),
+		Created: time.Now(),
+	}
+	data := storeFile{NextID: s.data.NextID + 1, Notes: append(s.notes(), n)}
+	return n, s.commit(data)
+}
+
+// Complete marks a to-do as done.
+func (s *Store) Complete(chatID int64, id int) (Note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, n := range s.data.Notes {
+		if n.ID == id && n.ChatID == chatID && n.Kind == KindTodo {
+			n.Done, n.DoneAt = true, time.Now()
+			data := storeFile{NextID: s.data.NextID, Notes: s.notes()}
+			data.Notes[i] = n
+			return n, s.commit(data)
+		}
+	}
+	return Note{}, ErrNotFound
+}
+
+// List returns the notes of a chat matching f, oldest first.
+func (s *Store) List(chatID int64, f Filter) []Note {
+	return s.list(f, func(n Note) bool { return n.ChatID == chatID })
+}
+
+// All returns the notes of every chat matching f, oldest first.
+func (s *Store) All(f Filter) []Note {
+	return s.list(f, func(Note) bool { return true })
+}
+
+func (s *Store) list(f Filter, inChat func(Note) bool) []Note {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []Note
+	for _, n := range s.data.Notes {
+		if !inChat(n) || (f.Kind != "" && n.Kind != f.Kind) || (n.Done && !f.WithDone) {
+			continue
+		}
+		if f.Tag != "" && !hasTag(n, f.Tag) {
+			continue
+		}
+		list = append(list, n)
+	}
+	return list
+}
+
+// Search returns the notes of a chat containing every word of query, best matches first.
+func (s *Store) Search(chatID int64, query string) []Note {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return nil
+	}
+
+	type match struct {
+		note  Note
+		score int
+	}
+	var matches []match
+	for _, n := range s.List(chatID, Filter{WithDone: true}) {
+		text := strings.ToLower(n.Text)
+		score := 0
+		for _, term := range terms {
+			count := strings.Count(text, strings.TrimPrefix(term, "#"))
+			if count == 0 {
+				score = 0
+				break
+			}
+			score += count
+		}
+		if score > 0 {
+			matches = append(matches, match{n, score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].note.Created.After(matches[j].note.Created)
+	})
+
+	results := make([]Note, len(matches))
+	for i, m := range matches {
+		results[i] = m.note
+	}
+	return results
+}
+
+// Markdown exports the notes and to-dos of a chat.
+func (s *Store) Markdown(chatID int64) string {
+	var b strings.Builder
+	b.WriteString("# Notes\n\n")
+	for _, n := range s.List(chatID, Filter{Kind: KindNote}) {
+		fmt.Fprintf(&b, "- %s (%s)\n", indent(n.Text), n.Created.Format("2006-01-02"))
+	}
+
+	b.WriteString("\n# To-dos\n\n")
+	for _, n := range s.List(chatID, Filter{Kind: KindTodo, WithDone: true}) {
+		box := " "
+		if n.Done {
+			box = "x"
+		}
+		fmt.Fprintf(&b, "- [%s] %s\n", box, indent(n.Text))
+	}
+	return b.String()
+}
+
+// indent indents the lines after the first of a list item, keeping them in the item.
+func indent(text string) string {
+	return strings.ReplaceAll(text, "\n", "\n  ")
+}
+
+// Tags returns the lowercased #hashtags in text.
+func Tags(text string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, word := range strings.Fields(text) {
+		if !strings.HasPrefix(word, "#") {
+			continue
+		}
+		tag := strings.ToLower(strings.TrimRightFunc(word[1:], func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func hasTag(n Note, tag string) bool {
+	tag = strings.ToLower(strings.TrimPrefix(tag, "#"))
+	for _, t := range n.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// notes returns a copy of the notes to change, so they are only replaced once saved.
+func (s *Store) notes() []Note {
+	return append([]Note{}, s.data.Notes...)
+}
+
+// commit saves data and, if that worked, makes it the store's.
+func (s *Store) commit(data storeFile) error {
+	if err := s.file.Save(data); err != nil {
+		return err
+	}
+	s.data = data
+	return nil
+}
@@ -0,0 +1,223 @@
+package notes
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func mustAdd(t *testing.T, s *Store, chatID int64, kind, text string) Note {
+	t.Helper()
+	n, err := s.Add(chatID, kind, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func ids(notes []Note) []int {
+	ids := []int{}
+	for _, n := range notes {
+		ids = append(ids, n.ID)
+	}
+	return ids
+}
+
+func TestTags(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"buy milk", nil},
+		{"buy milk #Groceries", []string{"groceries"}},
+		{"#work: call Ana, #work again", []string{"work"}},
+		{"#café and #2026!", []string{"café", "2026"}},
+		{"a lone # and #! are no tags", nil},
+		{"line one #a\nline two #b", []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		if got := Tags(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tags(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	s := newTestStore(t)
+	note := mustAdd(t, s, 1, KindNote, "idea #work")
+	todo := mustAdd(t, s, 1, KindTodo, "call Ana #work")
+	done := mustAdd(t, s, 1, KindTodo, "buy milk #home")
+	other := mustAdd(t, s, 2, KindNote, "another chat #work")
+	if _, err := s.Complete(1, done.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		chatID int64
+		filter Filter
+		want   []int
+	}{
+		{"everything open", 1, Filter{}, []int{note.ID, todo.ID}},
+		{"with done", 1, Filter{WithDone: true}, []int{note.ID, todo.ID, done.ID}},
+		{"notes", 1, Filter{Kind: KindNote}, []int{note.ID}},
+		{"to-dos with done", 1, Filter{Kind: KindTodo, WithDone: true}, []int{todo.ID, done.ID}},
+		{"tag", 1, Filter{Tag: "work"}, []int{note.ID, todo.ID}},
+		{"tag with hash and capitals", 1, Filter{Tag: "#Work"}, []int{note.ID, todo.ID}},
+		{"done tag without done", 1, Filter{Tag: "home"}, []int{}},
+		{"other chat", 2, Filter{}, []int{other.ID}},
+		{"unknown chat", 3, Filter{}, []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(s.List(tt.chatID, tt.filter)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("List = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got, want := ids(s.All(Filter{Tag: "work"})), []int{note.ID, todo.ID, other.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("All = %v, want %v", got, want)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	s := newTestStore(t)
+	once := mustAdd(t, s, 1, KindNote, "milk")
+	twice := mustAdd(t, s, 1, KindNote, "milk, more milk #shopping")
+	older := mustAdd(t, s, 1, KindTodo, "bread")
+	newer := mustAdd(t, s, 1, KindTodo, "bread")
+	mustAdd(t, s, 2, KindNote, "milk in another chat")
+
+	// equal scores are ranked newest first
+	s.data.Notes[2].Created = time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	s.data.Notes[3].Created = time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"milk", []int{twice.ID, once.ID}},
+		{"MILK", []int{twice.ID, once.ID}},
+		{"milk #shopping", []int{twice.ID}},
+		{"milk bread", []int{}},
+		{"bread", []int{newer.ID, older.ID}},
+		{"", []int{}},
+	}
+	for _, tt := range tests {
+		if got := ids(s.Search(1, tt.query)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	s := newTestStore(t)
+	todo := mustAdd(t, s, 1, KindTodo, "call Ana")
+	note := mustAdd(t, s, 1, KindNote, "an idea")
+
+	tests := []struct {
+		name   string
+		chatID int64
+		id     int
+	}{
+		{"wrong chat", 2, todo.ID},
+		{"wrong kind", 1, note.ID},
+		{"unknown ID", 1, 99},
+	}
+	for _, tt := range tests {
+		if _, err := s.Complete(tt.chatID, tt.id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: err = %v, want ErrNotFound", tt.name, err)
+		}
+	}
+	if got := s.List(1, Filter{Kind: KindTodo}); len(got) != 1 {
+		t.Fatalf("open to-dos = %+v, want the one completed in no chat", got)
+	}
+
+	done, err := s.Complete(1, todo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !done.Done || done.DoneAt.IsZero() {
+		t.Errorf("completed to-do = %+v, want it done", done)
+	}
+
+	reopened, err := NewStore(filepath.Dir(s.file.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.List(1, Filter{Kind: KindTodo}); len(got) != 0 {
+		t.Errorf("open to-dos after reopening = %+v, want none", got)
+	}
+}
+
+func TestFailedSaveChangesNothing(t *testing.T) {
+	s := newTestStore(t)
+	todo := mustAdd(t, s, 1, KindTodo, "call Ana")
+
+	// without its directory, the store can't be saved
+	if err := os.RemoveAll(filepath.Dir(s.file.Path)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Add(1, KindNote, "lost"); err == nil {
+		t.Error("Add succeeded without saving")
+	}
+	if _, err := s.Complete(1, todo.ID); err == nil {
+		t.Error("Complete succeeded without saving")
+	}
+
+	got := s.List(1, Filter{WithDone: true})
+	if len(got) != 1 || got[0].Done {
+		t.Errorf("notes = %+v, want the open to-do only", got)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.file.Path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if next := mustAdd(t, s, 1, KindNote, "kept"); next.ID != todo.ID+1 {
+		t.Errorf("next ID = %d, want %d, as the failed Add took none", next.ID, todo.ID+1)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	s := newTestStore(t)
+	mustAdd(t, s, 1, KindNote, "first line\nsecond line")
+	mustAdd(t, s, 1, KindTodo, "open")
+	done := mustAdd(t, s, 1, KindTodo, "steps:\n1. a\n2. b")
+	mustAdd(t, s, 2, KindNote, "another chat")
+	if _, err := s.Complete(1, done.ID); err != nil {
+		t.Fatal(err)
+	}
+	for i := range s.data.Notes {
+		s.data.Notes[i].Created = time.Date(2026, time.March, 11, 0, 0, 0, 0, time.UTC)
+	}
+
+	want := `# Notes
+
+- first line
+  second line (2026-03-11)
+
+# To-dos
+
+- [ ] open
+- [x] steps:
+  1. a
+  2. b
+`
+	if got := s.Markdown(1); got != want {
+		t.Errorf("Markdown =\n%s\nwant\n%s", got, want)
+	}
+}
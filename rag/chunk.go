@@ -0,0 +1,61 @@
+package rag
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// ChunkSize is the longest chunk, in bytes, documents are split into before indexing.
+const ChunkSize = 1000
+
+// Chunk splits text into chunks of at most size bytes, keeping paragraphs together
+// where possible and otherwise splitting on spaces.
+func Chunk(text string, size int) []string {
+	var chunks []string
+	var current strings.Builder
+
+	flush := func() {
+		if s := strings.TrimSpace(current.String()); s != "" {
+			chunks = append(chunks, s)
+		}
+		current.Reset()
+	}
+
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		if current.Len() > 0 && current.Len()+len(paragraph)+2 > size {
+			flush()
+		}
+
+		for len(paragraph) > size {
+			cut := strings.LastIndex(paragraph[:size], " ")
+			if cut <= 0 {
+				// back up to the start of a rune so none is split
+				cut = size
+				for cut > 0 && !utf8.RuneStart(paragraph[cut]) {
+					cut--
+				}
+				if cut == 0 {
+					_, cut = utf8.DecodeRuneInString(paragraph)
+				}
+			}
+			if current.Len() > 0 {
+				flush()
+			}
+			current.WriteString(paragraph[:cut])
+			flush()
+			paragraph = strings.TrimSpace(paragraph[cut:])
+		}
+
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(paragraph)
+	}
+	flush()
+
+	return chunks
+}
@@ -0,0 +1,85 @@
+// Package rag retrieves snippets of personal data for the assistant: an embeddings
+// provider and a local vector index searched by cosine similarity.
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"duarteocarmo/ambrosio/model"
+)
+
+const (
+	EmbeddingsEndpoint    = "/v1/embeddings"
+	DefaultEmbeddingModel = "togethercomputer/m2-bert-80M-8k-retrieval"
+	// EmbedBatchSize is how many texts are embedded per request.
+	EmbedBatchSize = 32
+)
+
+// Embedder turns texts into vectors, one per text.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// TogetherEmbedder embeds texts with the Together embeddings API.
+type TogetherEmbedder struct {
+	Client *model.Client
+	Model  string
+}
+
+// NewEmbedder returns the embedder configured by EMBEDDINGS_MODEL, using the Together
+// client settings.
+func NewEmbedder() (*TogetherEmbedder, error) {
+	client, err := model.NewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	modelID := os.Getenv("EMBEDDINGS_MODEL")
+	if modelID == "" {
+		modelID = DefaultEmbeddingModel
+	}
+	return &TogetherEmbedder{Client: client, Model: modelID}, nil
+}
+
+func (e *TogetherEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	var vectors [][]float32
+
+	for start := 0; start < len(texts); start += EmbedBatchSize {
+		end := min(start+EmbedBatchSize, len(texts))
+
+		body, err := e.Client.Post(ctx, EmbeddingsEndpoint, map[string]interface{}{
+			"model": e.Model,
+			"input": texts[start:end],
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var response struct {
+			Data []struct {
+				Index     int       `json:"index"`
+				Embedding []float32 `json:"embedding"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			return nil, err
+		}
+		if len(response.Data) != end-start {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", end-start, len(response.Data))
+		}
+
+		batch := make([][]float32, end-start)
+		for _, d := range response.Data {
+			if d.Index < 0 || d.Index >= len(batch) {
+				return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+			}
+			batch[d.Index] = d.Embedding
+		}
+		vectors = append(vectors, batch...)
+	}
+
+	return vectors, nil
+}
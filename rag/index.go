@@ -0,0 +1,203 @@
+package rag
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"duarteocarmo/ambrosio/jsonfile"
+)
+
+const FileName = "index.json"
+
+// Document is a piece of text to retrieve, such as a note or a chunk of a file.
+type Document struct {
+	// Source identifies where the text comes from, such as "note 3" or "photo 1a2b",
+	// and is shown when citing it.
+	Source string `json:"source"`
+	Text   string `json:"text"`
+}
+
+// Result is a document found by Search, with its cosine similarity to the query.
+type Result struct {
+	Document
+	Score float64
+}
+
+type entry struct {
+	Document
+	Hash   string    `json:"hash"`
+	Vector []float32 `json:"vector"`
+}
+
+// Index is a vector index kept in memory and, when opened from a directory, saved to a JSON file.
+type Index struct {
+	file jsonfile.File
+
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+// NewIndex returns an empty index kept in memory only.
+func NewIndex() *Index {
+	return &Index{entries: map[string]entry{}}
+}
+
+// OpenIndex loads the index from dir, creating the directory if needed.
+func OpenIndex(dir string) (*Index, error) {
+	file, err := jsonfile.Open(dir, FileName)
+	if err != nil {
+		return nil, err
+	}
+	// vectors make the index too large to read, so it is saved compact
+	file.Compact = true
+
+	idx := &Index{file: file, entries: map[string]entry{}}
+
+	var entries []entry
+	if err := file.Load(&entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		idx.entries[e.Source] = e
+	}
+	return idx, nil
+}
+
+// Sync makes the documents whose source starts with prefix exactly docs, embedding
+// only the documents that are new or changed.
+func (idx *Index) Sync(ctx context.Context, embedder Embedder, prefix string, docs []Document) error {
+	idx.mu.Lock()
+	var changed []Document
+	for _, doc := range docs {
+		if e, ok := idx.entries[doc.Source]; !ok || e.Hash != hash(doc.Text) {
+			changed = append(changed, doc)
+		}
+	}
+	idx.mu.Unlock()
+
+	// embed without the lock, so searches don't wait on the network
+	var vectors [][]float32
+	if len(changed) > 0 {
+		texts := make([]string, len(changed))
+		for i, doc := range changed {
+			texts[i] = doc.Text
+		}
+		var err error
+		if vectors, err = embedder.Embed(ctx, texts); err != nil {
+			return fmt.Errorf("error embedding documents: %w", err)
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	wanted := map[string]bool{}
+	for _, doc := range docs {
+		wanted[doc.Source] = true
+	}
+	removed := false
+	for source := range idx.entries {
+		if strings.HasPrefix(source, prefix) && !wanted[source] {
+			delete(idx.entries, source)
+			removed = true
+		}
+	}
+
+	if len(changed) == 0 && !removed {
+		return nil
+	}
+	for i, doc := range changed {
+		idx.entries[doc.Source] = entry{Document: doc, Hash: hash(doc.Text), Vector: vectors[i]}
+	}
+	return idx.save()
+}
+
+// Search returns up to k documents most similar to query, with a score of at least
+// minScore. Given prefixes, only documents whose source starts with one of them are searched.
+func (idx *Index) Search(ctx context.Context, embedder Embedder, query string, k int, minScore float64, prefixes ...string) ([]Result, error) {
+	vectors, err := embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("error embedding query: %w", err)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	var results []Result
+	for _, e := range idx.entries {
+		if !hasPrefix(e.Source, prefixes) {
+			continue
+		}
+		score := cosine(vectors[0], e.Vector)
+		if score >= minScore {
+			results = append(results, Result{Document: e.Document, Score: score})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
+
+// Len returns the number of indexed documents.
+func (idx *Index) Len() int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return len(idx.entries)
+}
+
+func (idx *Index) save() error {
+	if idx.file.Path == "" {
+		return nil
+	}
+
+	entries := make([]entry, 0, len(idx.entries))
+	for _, e := range idx.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Source < entries[j].Source })
+
+	return idx.file.Save(entries)
+}
+
+func hasPrefix(source string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(source, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func hash(text string) string {
+	sum := sha1.Sum([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,190 @@
+package reminders
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EveryDay     = "day"
+	EveryWeekday = "weekday"
+
+	// MaxDuration is the furthest ahead "in ..." reminders can be set.
+	MaxDuration = 10 * 365 * 24 * time.Hour
+)
+
+// Usage explains the reminder formats Parse understands.
+const Usage = `Tell me when, then what:
+/remind in 2h call the plumber
+/remind in 30 minutes take the pizza out
+/remind at 18:30 water the plants
+/remind tomorrow 9:00 pay rent
+/remind on friday 20:00 book a table
+/remind every monday 9:00 weekly review
+/remind every day 8:00 stretch
+/remind every weekday 8:30 standup`
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+var units = map[string]time.Duration{
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+// Parse reads a reminder such as "in 2h call the plumber" or "every monday 9:00 review",
+// with times in loc, and returns it scheduled for its first occurrence after now.
+func Parse(text string, now time.Time, loc *time.Location) (Reminder, error) {
+	words := strings.Fields(text)
+	if len(words) < 2 {
+		return Reminder{}, fmt.Errorf("tell me when and what to remind you of")
+	}
+	now = now.In(loc)
+
+	var r Reminder
+	var rest []string
+
+	switch strings.ToLower(words[0]) {
+	case "in":
+		d, n, err := parseDuration(words[1:])
+		if err != nil {
+			return Reminder{}, err
+		}
+		r.Due = now.Add(d)
+		rest = words[1+n:]
+
+	case "at":
+		hour, minute, err := parseClock(words[1])
+		if err != nil {
+			return Reminder{}, err
+		}
+		r.Due = nextAt(now, hour, minute, func(time.Time) bool { return true })
+		rest = words[2:]
+
+	case "tomorrow":
+		hour, minute, n, err := parseAt(words[1:])
+		if err != nil {
+			return Reminder{}, err
+		}
+		tomorrow := now.AddDate(0, 0, 1)
+		r.Due = time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), hour, minute, 0, 0, loc)
+		rest = words[1+n:]
+
+	case "on":
+		day, ok := weekdays[strings.ToLower(words[1])]
+		if !ok {
+			return Reminder{}, fmt.Errorf("%q is not a day of the week", words[1])
+		}
+		hour, minute, n, err := parseAt(words[2:])
+		if err != nil {
+			return Reminder{}, err
+		}
+		r.Due = nextAt(now, hour, minute, func(t time.Time) bool { return t.Weekday() == day })
+		rest = words[2+n:]
+
+	case "every":
+		every := strings.ToLower(words[1])
+		if _, ok := weekdays[every]; !ok && every != EveryDay && every != EveryWeekday {
+			return Reminder{}, fmt.Errorf("repeat every day, weekday or a day of the week, not %q", words[1])
+		}
+		hour, minute, n, err := parseAt(words[2:])
+		if err != nil {
+			return Reminder{}, err
+		}
+		r.Every = every
+		r.At = fmt.Sprintf("%02d:%02d", hour, minute)
+		r.Due = r.next(now)
+		rest = words[2+n:]
+
+	default:
+		return Reminder{}, fmt.Errorf("start with in, at, tomorrow, on or every")
+	}
+
+	if len(rest) > 0 && strings.ToLower(rest[0]) == "to" {
+		rest = rest[1:]
+	}
+	r.Text = strings.Join(rest, " ")
+	if r.Text == "" {
+		return Reminder{}, fmt.Errorf("what should I remind you of?")
+	}
+	return r, nil
+}
+
+// parseDuration reads "2h", "1h30m", "90 minutes" or "3 days" from the start of words
+// and returns the duration and how many words it used. Durations over MaxDuration are
+// refused, so large numbers can't overflow into the past.
+func parseDuration(words []string) (time.Duration, int, error) {
+	if d, err := time.ParseDuration(words[0]); err == nil && d > 0 {
+		if d > MaxDuration {
+			return 0, 0, errTooFar
+		}
+		return d, 1, nil
+	}
+
+	number, unit := words[0], ""
+	if i := strings.IndexFunc(number, func(r rune) bool { return r < '0' || r > '9' }); i > 0 {
+		number, unit = number[:i], number[i:]
+	}
+	n, err := strconv.Atoi(number)
+	used := 1
+	if err == nil && unit == "" && len(words) > 1 {
+		unit = words[1]
+		used = 2
+	}
+	size := units[strings.ToLower(unit)]
+	if err != nil || n <= 0 || size == 0 {
+		return 0, 0, fmt.Errorf("%q is not a duration, try 2h, 30m or 3 days", strings.Join(words[:used], " "))
+	}
+	if int64(n) > int64(MaxDuration/size) {
+		return 0, 0, errTooFar
+	}
+	return time.Duration(n) * size, used, nil
+}
+
+var errTooFar = errors.New("that's too far ahead, reminders go up to 10 years")
+
+// parseAt reads "9:00" or "at 9:00" from the start of words.
+func parseAt(words []string) (int, int, int, error) {
+	used := 0
+	if len(words) > 0 && strings.ToLower(words[0]) == "at" {
+		words, used = words[1:], 1
+	}
+	if len(words) == 0 {
+		return 0, 0, 0, fmt.Errorf("tell me at what time, like 9:00")
+	}
+	hour, minute, err := parseClock(words[0])
+	return hour, minute, used + 1, err
+}
+
+// parseClock reads a 24-hour time such as 9:00 or 18:30.
+func parseClock(s string) (int, int, error) {
+	h, m, found := strings.Cut(s, ":")
+	hour, err1 := strconv.Atoi(h)
+	minute, err2 := strconv.Atoi(m)
+	if !found || err1 != nil || err2 != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, 0, fmt.Errorf("%q is not a time, use 24-hour times like 9:00 or 18:30", s)
+	}
+	return hour, minute, nil
+}
+
+// nextAt returns the first time after now at hour:minute on a day matching ok.
+func nextAt(now time.Time, hour, minute int, ok func(time.Time) bool) time.Time {
+	for i := 0; i <= 7; i++ {
+		day := now.AddDate(0, 0, i)
+		t := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, now.Location())
+		if t.After(now) && ok(t) {
+			return t
+		}
+	}
+	// unreachable: every weekday occurs within a week
+	return now
+}
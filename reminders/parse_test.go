@@ -0,0 +1,128 @@
+package reminders
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestParse(t *testing.T) {
+	lisbon := mustLocation(t, "Europe/Lisbon")
+	// a Wednesday
+	now := time.Date(2026, time.March, 11, 10, 0, 0, 0, lisbon)
+
+	tests := []struct {
+		text  string
+		due   time.Time
+		every string
+		at    string
+		what  string
+	}{
+		{"in 2h call the plumber", now.Add(2 * time.Hour), "", "", "call the plumber"},
+		{"in 90 minutes take the pizza out", now.Add(90 * time.Minute), "", "", "take the pizza out"},
+		{"in 3 days to pay rent", now.Add(72 * time.Hour), "", "", "pay rent"},
+		{"at 18:30 water the plants", time.Date(2026, time.March, 11, 18, 30, 0, 0, lisbon), "", "", "water the plants"},
+		{"at 9:00 stretch", time.Date(2026, time.March, 12, 9, 0, 0, 0, lisbon), "", "", "stretch"},
+		{"tomorrow 9:00 pay rent", time.Date(2026, time.March, 12, 9, 0, 0, 0, lisbon), "", "", "pay rent"},
+		{"on friday 20:00 book a table", time.Date(2026, time.March, 13, 20, 0, 0, 0, lisbon), "", "", "book a table"},
+		{"on wed at 11:00 later today", time.Date(2026, time.March, 11, 11, 0, 0, 0, lisbon), "", "", "later today"},
+		{"on wed 9:00 next week", time.Date(2026, time.March, 18, 9, 0, 0, 0, lisbon), "", "", "next week"},
+		{"every monday 9:00 weekly review", time.Date(2026, time.March, 16, 9, 0, 0, 0, lisbon), "monday", "09:00", "weekly review"},
+		{"every day 8:00 stretch", time.Date(2026, time.March, 12, 8, 0, 0, 0, lisbon), EveryDay, "08:00", "stretch"},
+		{"every day 10:30 coffee", time.Date(2026, time.March, 11, 10, 30, 0, 0, lisbon), EveryDay, "10:30", "coffee"},
+		{"every weekday at 8:30 standup", time.Date(2026, time.March, 12, 8, 30, 0, 0, lisbon), EveryWeekday, "08:30", "standup"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			r, err := Parse(tt.text, now, lisbon)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !r.Due.Equal(tt.due) {
+				t.Errorf("due %s, want %s", r.Due, tt.due)
+			}
+			if r.Every != tt.every || r.At != tt.at || r.Text != tt.what {
+				t.Errorf("got every %q at %q %q, want every %q at %q %q", r.Every, r.At, r.Text, tt.every, tt.at, tt.what)
+			}
+		})
+	}
+}
+
+func TestParseWeekdaySkipsWeekends(t *testing.T) {
+	utc := time.UTC
+	friday := time.Date(2026, time.March, 13, 9, 0, 0, 0, utc)
+
+	r, err := Parse("every weekday 8:30 standup", friday, utc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, time.March, 16, 8, 30, 0, 0, utc); !r.Due.Equal(want) {
+		t.Errorf("due %s, want Monday %s", r.Due, want)
+	}
+}
+
+func TestParseAcrossDST(t *testing.T) {
+	lisbon := mustLocation(t, "Europe/Lisbon")
+	// clocks go forward from 01:00 to 02:00 on Sunday 29 March 2026
+	saturday := time.Date(2026, time.March, 28, 10, 0, 0, 0, lisbon)
+
+	tests := []struct {
+		text string
+		due  time.Time
+	}{
+		// wall clock times keep their hour, now an hour closer in UTC
+		{"every day 9:00 stretch", time.Date(2026, time.March, 29, 8, 0, 0, 0, time.UTC)},
+		{"tomorrow 9:00 stretch", time.Date(2026, time.March, 29, 8, 0, 0, 0, time.UTC)},
+		{"on monday 9:00 stretch", time.Date(2026, time.March, 30, 8, 0, 0, 0, time.UTC)},
+		// durations are elapsed time, so a day later shows an hour later on the clock
+		{"in 1 day stretch", time.Date(2026, time.March, 29, 10, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			r, err := Parse(tt.text, saturday, lisbon)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !r.Due.Equal(tt.due) {
+				t.Errorf("due %s, want %s", r.Due.UTC(), tt.due)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	now := time.Date(2026, time.March, 11, 10, 0, 0, 0, time.UTC)
+
+	for _, text := range []string{
+		"",
+		"in",
+		"soon call mum",
+		"in 2 parsecs call mum",
+		"in 0h call mum",
+		"in 9999999999 days call mum",
+		"in 99999999999999999999 days call mum",
+		"in 3651 days call mum",
+		"in 87601h call mum",
+		"in 2h",
+		"at 25:00 call mum",
+		"at noon call mum",
+		"on someday 9:00 call mum",
+		"on friday",
+		"every month 9:00 pay rent",
+		"every monday review",
+	} {
+		if r, err := Parse(text, now, time.UTC); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", text, r)
+		}
+	}
+}
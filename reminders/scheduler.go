@@ -0,0 +1,53 @@
+package reminders
+
+import (
+	"context"
+	"time"
+
+	"duarteocarmo/ambrosio/logging"
+	"duarteocarmo/ambrosio/metrics"
+)
+
+// CheckInterval is how often the scheduler looks for due reminders.
+const CheckInterval = 30 * time.Second
+
+// Scheduler sends reminders from a Store when they are due.
+type Scheduler struct {
+	Store *Store
+	Send  func(ctx context.Context, r Reminder) error
+}
+
+// Run sends due reminders until ctx is done. Reminders that came due while the bot
+// was down are sent on start.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		s.sendDue(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) sendDue(ctx context.Context, now time.Time) {
+	for _, r := range s.Store.Due(now) {
+		logger := logging.FromContext(ctx).With("reminder_id", r.ID)
+
+		err := s.Send(ctx, r)
+		metrics.Reminders.Inc(metrics.Result(err))
+		if err != nil {
+			// left due, so it is retried on the next check
+			logger.Error("Error sending reminder", "error", err)
+			continue
+		}
+
+		if err := s.Store.MarkSent(r.ID, now); err != nil {
+			logger.Error("Error saving sent reminder", "error", err)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Package reminders schedules messages for later, once or repeating, persisted in a
+// JSON file so they survive restarts.
+package reminders
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"duarteocarmo/ambrosio/jsonfile"
+
+	// timezones work without the system database, which the runtime image lacks
+	_ "time/tzdata"
+)
+
+const (
+	FileName = "reminders.json"
+	// KeepFired is how long a fired reminder is kept so it can still be snoozed.
+	KeepFired = 24 * time.Hour
+)
+
+var ErrNotFound = errors.New("reminder not found")
+
+// Reminder is a message to send to a chat when it is due.
+type Reminder struct {
+	ID     string    `json:"id"`
+	ChatID int64     `json:"chat_id"`
+	Text   string    `json:"text"`
+	Due    time.Time `json:"due"`
+	// Every and At describe repeating reminders, such as every "monday" at "09:00".
+	Every string `json:"every,omitempty"`
+	At    string `json:"at,omitempty"`
+	// Fired is set on one-off reminders that were sent, until they expire.
+	Fired bool `json:"fired,omitempty"`
+}
+
+// Repeats reports whether the reminder repeats.
+func (r Reminder) Repeats() bool {
+	return r.Every != ""
+}
+
+// next returns the first occurrence of a repeating reminder after now, in now's location.
+func (r Reminder) next(now time.Time) time.Time {
+	hour, minute, _ := parseClock(r.At)
+	return nextAt(now, hour, minute, func(t time.Time) bool {
+		switch r.Every {
+		case EveryDay:
+			return true
+		case EveryWeekday:
+			return t.Weekday() != time.Saturday && t.Weekday() != time.Sunday
+		default:
+			return t.Weekday() == weekdays[r.Every]
+		}
+	})
+}
+
+// Schedule describes when the reminder is sent, in loc.
+func (r Reminder) Schedule(loc *time.Location) string {
+	if r.Repeats() {
+		return fmt.Sprintf("every %s at %s", r.Every, r.At)
+	}
+	return r.Due.In(loc).Format("Mon 2 Jan 15:04")
+}
+
+type storeFile struct {
+	Reminders []Reminder       `json:"reminders"`
+	Timezones map[int64]string `json:"timezones"`
+}
+
+// Store keeps reminders and the timezone of each chat in a JSON file.
+type Store struct {
+	file jsonfile.File
+
+	mu   sync.Mutex
+	data storeFile
+}
+
+// NewStore loads the store from dir, creating the directory if needed.
+func NewStore(dir string) (*Store, error) {
+	file, err := jsonfile.Open(dir, FileName)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{file: file, data: storeFile{Timezones: map[int64]string{}}}
+	if err := file.Load(&s.data); err != nil {
+		return nil, err
+	}
+	if s.data.Timezones == nil {
+		s.data.Timezones = map[int64]string{}
+	}
+	return s, nil
+}
+
+// Add saves a new reminder and returns it with its ID.
+func (s *Store) Add(r Reminder) (Reminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r.ID = newID()
+	s.data.Reminders = append(s.data.Reminders, r)
+	return r, s.save()
+}
+
+// Get returns the reminder with id.
+func (s *Store) Get(id string) (Reminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.data.Reminders {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return Reminder{}, ErrNotFound
+}
+
+// List returns the pending reminders of a chat, soonest first.
+func (s *Store) List(chatID int64) []Reminder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []Reminder
+	for _, r := range s.data.Reminders {
+		if r.ChatID == chatID && !r.Fired {
+			list = append(list, r)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Due.Before(list[j].Due) })
+	return list
+}
+
+// Remove deletes a reminder, stopping it for good if it repeats.
+func (s *Store) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, r := range s.data.Reminders {
+		if r.ID == id {
+			s.data.Reminders = append(s.data.Reminders[:i], s.data.Reminders[i+1:]...)
+			return s.save()
+		}
+	}
+	return ErrNotFound
+}
+
+// Snooze puts a one-off reminder off by d, from when it fired or, if it is still
+// pending, from when it is due. A repeating reminder keeps its schedule, so snoozing
+// the occurrence just sent adds a copy sent once, d from now.
+func (s *Store) Snooze(id string, d time.Duration, now time.Time) (Reminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, r := range s.data.Reminders {
+		if r.ID != id {
+			continue
+		}
+		if r.Repeats() {
+			snoozed := Reminder{ID: newID(), ChatID: r.ChatID, Text: r.Text, Due: now.Add(d)}
+			s.data.Reminders = append(s.data.Reminders, snoozed)
+			return snoozed, s.save()
+		}
+		from := r.Due
+		if from.Before(now) {
+			from = now
+		}
+		r.Due, r.Fired = from.Add(d), false
+		s.data.Reminders[i] = r
+		return r, s.save()
+	}
+	return Reminder{}, ErrNotFound
+}
+
+// Due returns the reminders to send at now.
+func (s *Store) Due(now time.Time) []Reminder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []Reminder
+	for _, r := range s.data.Reminders {
+		if !r.Fired && !r.Due.After(now) {
+			due = append(due, r)
+		}
+	}
+	return due
+}
+
+// MarkSent records that a reminder was sent, scheduling the next occurrence of repeating
+// ones and dropping one-off reminders that fired more than KeepFired ago.
+func (s *Store) MarkSent(id string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.data.Reminders[:0]
+	for _, r := range s.data.Reminders {
+		switch {
+		case r.ID == id && r.Repeats():
+			r.Due = r.next(now.In(s.location(r.ChatID)))
+		case r.ID == id:
+			r.Fired = true
+		case r.Fired && now.Sub(r.Due) > KeepFired:
+			continue
+		}
+		kept = append(kept, r)
+	}
+	s.data.Reminders = kept
+	return s.save()
+}
+
+// Location returns the timezone of a chat, DEFAULT_TIMEZONE if it has none, or UTC.
+func (s *Store) Location(chatID int64) *time.Location {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.location(chatID)
+}
+
+func (s *Store) location(chatID int64) *time.Location {
+	name, ok := s.data.Timezones[chatID]
+	if !ok {
+		name = os.Getenv("DEFAULT_TIMEZONE")
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// SetTimezone sets the timezone of a chat, such as Europe/Lisbon.
+func (s *Store) SetTimezone(chatID int64, name string) (*time.Location, error) {
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "" || name == "Local" {
+		return nil, fmt.Errorf("unknown timezone %q, use a name like Europe/Lisbon", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Timezones[chatID] = loc.String()
+	return loc, s.save()
+}
+
+func (s *Store) save() error {
+	return s.file.Save(s.data)
+}
+
+func newID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,173 @@
+package reminders
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	t.Setenv("DEFAULT_TIMEZONE", "")
+	s, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func mustAdd(t *testing.T, s *Store, r Reminder) Reminder {
+	t.Helper()
+	r, err := s.Add(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestStoreDueAndMarkSent(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Date(2026, time.March, 11, 10, 0, 0, 0, time.UTC)
+
+	once := mustAdd(t, s, Reminder{ChatID: 1, Text: "call mum", Due: now.Add(-time.Minute)})
+	later := mustAdd(t, s, Reminder{ChatID: 1, Text: "later", Due: now.Add(time.Hour)})
+
+	due := s.Due(now)
+	if len(due) != 1 || due[0].ID != once.ID {
+		t.Fatalf("due %+v, want only %q", due, once.Text)
+	}
+
+	if err := s.MarkSent(once.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if due := s.Due(now); len(due) != 0 {
+		t.Errorf("due %+v after sending", due)
+	}
+	if list := s.List(1); len(list) != 1 || list[0].ID != later.ID {
+		t.Errorf("pending %+v, want only %q", list, later.Text)
+	}
+	if _, err := s.Get(once.ID); err != nil {
+		t.Errorf("fired reminder was dropped before it could be snoozed: %v", err)
+	}
+
+	// sending anything after KeepFired drops the old one
+	if err := s.MarkSent(later.ID, now.Add(KeepFired+time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(once.ID); err != ErrNotFound {
+		t.Errorf("fired reminder kept after %s: %v", KeepFired, err)
+	}
+}
+
+func TestStoreRepeatsInTheChatTimezone(t *testing.T) {
+	s := newTestStore(t)
+	if _, err := s.SetTimezone(1, "Europe/Lisbon"); err != nil {
+		t.Fatal(err)
+	}
+	lisbon := s.Location(1)
+
+	// sent on the Monday before clocks go forward on 29 March 2026
+	sent := time.Date(2026, time.March, 23, 9, 0, 0, 0, lisbon)
+	r := mustAdd(t, s, Reminder{ChatID: 1, Text: "review", Every: "monday", At: "09:00", Due: sent})
+
+	if err := s.MarkSent(r.ID, sent.UTC()); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.Get(r.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, time.March, 30, 8, 0, 0, 0, time.UTC); !r.Due.Equal(want) {
+		t.Errorf("next due %s, want 09:00 summer time, %s", r.Due.UTC(), want)
+	}
+}
+
+func TestStoreSnooze(t *testing.T) {
+	now := time.Date(2026, time.March, 11, 10, 0, 0, 0, time.UTC)
+
+	t.Run("pending", func(t *testing.T) {
+		s := newTestStore(t)
+		r := mustAdd(t, s, Reminder{ChatID: 1, Text: "trip", Due: now.Add(72 * time.Hour)})
+
+		snoozed, err := s.Snooze(r.ID, time.Hour, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := now.Add(73 * time.Hour); !snoozed.Due.Equal(want) {
+			t.Errorf("due %s, want an hour after it was due, %s", snoozed.Due, want)
+		}
+	})
+
+	t.Run("fired", func(t *testing.T) {
+		s := newTestStore(t)
+		r := mustAdd(t, s, Reminder{ChatID: 1, Text: "call mum", Due: now.Add(-10 * time.Minute)})
+		if err := s.MarkSent(r.ID, now.Add(-10*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+
+		snoozed, err := s.Snooze(r.ID, time.Hour, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := now.Add(time.Hour); !snoozed.Due.Equal(want) || snoozed.Fired {
+			t.Errorf("got due %s fired %v, want pending at %s", snoozed.Due, snoozed.Fired, want)
+		}
+		if list := s.List(1); len(list) != 1 {
+			t.Errorf("pending %+v, want the snoozed reminder", list)
+		}
+	})
+
+	t.Run("repeating", func(t *testing.T) {
+		s := newTestStore(t)
+		next := now.Add(7 * 24 * time.Hour)
+		r := mustAdd(t, s, Reminder{ChatID: 1, Text: "review", Every: "wednesday", At: "10:00", Due: next})
+
+		snoozed, err := s.Snooze(r.ID, time.Hour, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snoozed.ID == r.ID || snoozed.Repeats() || !snoozed.Due.Equal(now.Add(time.Hour)) {
+			t.Errorf("snoozed %+v, want a one-off copy in an hour", snoozed)
+		}
+		if kept, _ := s.Get(r.ID); !kept.Due.Equal(next) {
+			t.Errorf("repeating reminder moved to %s", kept.Due)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		s := newTestStore(t)
+		if _, err := s.Snooze("nope", time.Hour, now); err != ErrNotFound {
+			t.Errorf("err = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestStorePersists(t *testing.T) {
+	t.Setenv("DEFAULT_TIMEZONE", "")
+	dir := t.TempDir()
+	s, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := mustAdd(t, s, Reminder{ChatID: 1, Text: "call mum", Due: time.Date(2026, time.March, 11, 10, 0, 0, 0, time.UTC)})
+	if _, err := s.SetTimezone(1, "Europe/Lisbon"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetTimezone(1, "Mars/Olympus"); err == nil {
+		t.Error("set an unknown timezone")
+	}
+
+	reopened, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := reopened.Get(r.ID); err != nil || got.Text != r.Text || !got.Due.Equal(r.Due) {
+		t.Errorf("reloaded %+v (%v), want %+v", got, err, r)
+	}
+	if loc := reopened.Location(1); loc.String() != "Europe/Lisbon" {
+		t.Errorf("timezone %s after reload", loc)
+	}
+	if loc := reopened.Location(2); loc != time.UTC {
+		t.Errorf("chat without a timezone uses %s, want UTC", loc)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const CheckTimeout = 5 * time.Second
+
+// Check reports whether a dependency the bot needs is usable.
+type Check func(ctx context.Context) error
+
+// HealthHandler answers liveness probes: the process is up and serving HTTP.
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	})
+}
+
+// ReadyHandler runs every check concurrently and answers 503 if any of them fails.
+func ReadyHandler(checks map[string]Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), CheckTimeout)
+		defer cancel()
+
+		type result struct {
+			name string
+			err  error
+		}
+		results := make(chan result, len(checks))
+		for name, check := range checks {
+			go func(name string, check Check) {
+				results <- result{name: name, err: check(ctx)}
+			}(name, check)
+		}
+
+		status := map[string]string{}
+		ready := true
+		for range checks {
+			res := <-results
+			if res.err != nil {
+				status[res.name] = res.err.Error()
+				ready = false
+				continue
+			}
+			status[res.name] = "ok"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ready": ready, "checks": status})
+	})
+}
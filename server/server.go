@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultListenAddr = ":8080"
+	ShutdownTimeout   = 10 * time.Second
+)
+
+// Server is the embedded HTTP server the bot exposes its endpoints on.
+type Server struct {
+	Addr        string
+	TLSCertFile string
+	TLSKeyFile  string
+
+	mux  *http.ServeMux
+	done chan struct{}
+}
+
+func New(addr string) *Server {
+	if addr == "" {
+		addr = DefaultListenAddr
+	}
+	return &Server{Addr: addr, mux: http.NewServeMux(), done: make(chan struct{})}
+}
+
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Done is closed once Run has returned and no handler is running anymore.
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Run serves until ctx is done and then shuts down, waiting for in-flight requests.
+func (s *Server) Run(ctx context.Context) error {
+	defer close(s.done)
+
+	httpServer := &http.Server{
+		Addr:              s.Addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("HTTP server listening", "addr", s.Addr)
+		if s.TLSCertFile != "" && s.TLSKeyFile != "" {
+			errs <- httpServer.ListenAndServeTLS(s.TLSCertFile, s.TLSKeyFile)
+		} else {
+			errs <- httpServer.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+var secretTokenPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// RegisterWebhook points Telegram at publicURL, asking it to send secret in SecretTokenHeader.
+func RegisterWebhook(bot *tgbotapi.BotAPI, publicURL, secret string) error {
+	if !secretTokenPattern.MatchString(secret) {
+		return fmt.Errorf("webhook secret must be 1-256 characters of A-Z, a-z, 0-9, _ and -")
+	}
+
+	params := tgbotapi.Params{}
+	params["url"] = publicURL
+	params["secret_token"] = secret
+
+	// setWebhook is called directly because WebhookConfig has no secret_token field
+	resp, err := bot.MakeRequest("setWebhook", params)
+	if err != nil {
+		return fmt.Errorf("error setting webhook: %w", err)
+	}
+	if !resp.Ok {
+		return fmt.Errorf("error setting webhook: %s", resp.Description)
+	}
+
+	slog.Info("Registered webhook", "url", publicURL)
+	return nil
+}
+
+// WebhookHandler decodes updates posted by Telegram and feeds them into updates.
+// Requests are rejected once ctx is done so Telegram redelivers them after a restart.
+func WebhookHandler(ctx context.Context, secret string, updates chan<- tgbotapi.Update) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := r.Header.Get(SecretTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			slog.Warn("Rejected webhook request with invalid secret token", "remote_addr", r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var update tgbotapi.Update
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "invalid update", http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case updates <- update:
+			w.WriteHeader(http.StatusOK)
+		case <-ctx.Done():
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		case <-r.Context().Done():
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSTTModel = "whisper-1"
+	RequestTimeout  = 120 * time.Second
+)
+
+// Transcriber turns recorded speech into text.
+type Transcriber interface {
+	Transcribe(ctx context.Context, filename string, audio []byte) (string, error)
+}
+
+// WhisperTranscriber talks to a Whisper-compatible HTTP API: OpenAI's
+// /v1/audio/transcriptions, or the /inference endpoint of a whisper.cpp server.
+// Audio is converted to 16 kHz WAV first, since whisper.cpp servers only read other
+// formats, like the OGG/Opus of voice notes, when started with --convert.
+type WhisperTranscriber struct {
+	URL        string
+	APIKey     string
+	Model      string
+	Language   string
+	HTTPClient *http.Client
+}
+
+// NewTranscriber configures a WhisperTranscriber from STT_URL, STT_API_KEY, STT_MODEL and STT_LANGUAGE.
+func NewTranscriber() (Transcriber, error) {
+	url := os.Getenv("STT_URL")
+	if url == "" {
+		return nil, fmt.Errorf("voice transcription is not configured, set STT_URL")
+	}
+
+	model := os.Getenv("STT_MODEL")
+	if model == "" {
+		model = DefaultSTTModel
+	}
+
+	return &WhisperTranscriber{
+		URL:        url,
+		APIKey:     os.Getenv("STT_API_KEY"),
+		Model:      model,
+		Language:   os.Getenv("STT_LANGUAGE"),
+		HTTPClient: &http.Client{Timeout: RequestTimeout},
+	}, nil
+}
+
+func (w *WhisperTranscriber) Transcribe(ctx context.Context, filename string, audio []byte) (string, error) {
+	wav, err := ToWAV(ctx, audio)
+	if err != nil {
+		return "", err
+	}
+	audio = wav
+	filename = strings.TrimSuffix(filename, path.Ext(filename)) + ".wav"
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(audio); err != nil {
+		return "", err
+	}
+
+	fields := map[string]string{"model": w.Model, "response_format": "json", "language": w.Language}
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+		if err := form.WriteField(name, value); err != nil {
+			return "", err
+		}
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.URL, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if w.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+w.APIKey)
+	}
+
+	respBody, err := do(w.HTTPClient, req)
+	if err != nil {
+		return "", fmt.Errorf("transcription failed: %w", err)
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", fmt.Errorf("failed to decode transcription: %w", err)
+	}
+
+	text := strings.TrimSpace(result.Text)
+	if text == "" {
+		return "", fmt.Errorf("no speech recognised")
+	}
+	return text, nil
+}
+
+// ToWAV converts audio in any format ffmpeg understands to 16 kHz mono WAV, the input
+// Whisper models expect. It is written to a file rather than a pipe, so ffmpeg can fill
+// in the sizes in the WAV header.
+func ToWAV(ctx context.Context, audio []byte) ([]byte, error) {
+	out, err := os.CreateTemp("", "ambrosio-*.wav")
+	if err != nil {
+		return nil, err
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-loglevel", "error", "-y",
+		"-i", "pipe:0", "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", out.Name())
+
+	var stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(audio)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg conversion failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return os.ReadFile(out.Name())
+}
+
+func do(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
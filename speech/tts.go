@@ -0,0 +1,125 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+)
+
+const (
+	OpenAIProvider  = "openai"
+	PiperProvider   = "piper"
+	DefaultTTSModel = "tts-1"
+	DefaultTTSVoice = "alloy"
+)
+
+// Synthesizer turns text into speech encoded as OGG/Opus, the format of Telegram voice notes.
+type Synthesizer interface {
+	Synthesize(ctx context.Context, text string) ([]byte, error)
+}
+
+// OpenAISynthesizer talks to an OpenAI-compatible /v1/audio/speech endpoint.
+type OpenAISynthesizer struct {
+	URL        string
+	APIKey     string
+	Model      string
+	Voice      string
+	HTTPClient *http.Client
+}
+
+// PiperSynthesizer talks to a Piper HTTP server, which answers with WAV that is
+// converted to OGG/Opus with ffmpeg.
+type PiperSynthesizer struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+// NewSynthesizer configures a Synthesizer from TTS_PROVIDER (openai or piper),
+// TTS_URL, TTS_API_KEY, TTS_MODEL and TTS_VOICE.
+func NewSynthesizer() (Synthesizer, error) {
+	url := os.Getenv("TTS_URL")
+	if url == "" {
+		return nil, fmt.Errorf("voice replies are not configured, set TTS_URL")
+	}
+
+	client := &http.Client{Timeout: RequestTimeout}
+
+	switch provider := os.Getenv("TTS_PROVIDER"); provider {
+	case "", OpenAIProvider:
+		model := os.Getenv("TTS_MODEL")
+		if model == "" {
+			model = DefaultTTSModel
+		}
+		voice := os.Getenv("TTS_VOICE")
+		if voice == "" {
+			voice = DefaultTTSVoice
+		}
+		return &OpenAISynthesizer{URL: url, APIKey: os.Getenv("TTS_API_KEY"), Model: model, Voice: voice, HTTPClient: client}, nil
+	case PiperProvider:
+		return &PiperSynthesizer{URL: url, HTTPClient: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown TTS_PROVIDER %q, use %s or %s", provider, OpenAIProvider, PiperProvider)
+	}
+}
+
+func (o *OpenAISynthesizer) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	payload, err := json.Marshal(map[string]string{
+		"model":           o.Model,
+		"input":           text,
+		"voice":           o.Voice,
+		"response_format": "opus",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", o.URL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.APIKey)
+	}
+
+	audio, err := do(o.HTTPClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("speech synthesis failed: %w", err)
+	}
+	return audio, nil
+}
+
+func (p *PiperSynthesizer) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", p.URL, bytes.NewReader([]byte(text)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	wav, err := do(p.HTTPClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("speech synthesis failed: %w", err)
+	}
+
+	return ToOggOpus(ctx, wav)
+}
+
+// ToOggOpus converts audio in any format ffmpeg understands to OGG/Opus.
+func ToOggOpus(ctx context.Context, audio []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-loglevel", "error",
+		"-i", "pipe:0", "-c:a", "libopus", "-b:a", "32k", "-f", "ogg", "pipe:1")
+
+	var out, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(audio)
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg conversion failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out.Bytes(), nil
+}
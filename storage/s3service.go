@@ -5,23 +5,30 @@ import (
 	"context"
 	"crypto/sha1"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
+	"image/jpeg"
 	"io"
-	"log"
 	"math"
 	"net/http"
 	"os"
 	"path"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
-	_ "image/jpeg"
 	_ "image/png"
 
+	"duarteocarmo/ambrosio/logging"
+	"duarteocarmo/ambrosio/metrics"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/chai2010/webp"
 )
 
@@ -31,11 +38,21 @@ const (
 )
 
 type Photo struct {
-	Url      string
-	ID       string
-	Date     string
-	Caption  *string
-	Location *string
+	Url string
+	// Data is the photo itself, used instead of downloading Url when set.
+	Data       []byte
+	ID         string
+	Date       string
+	Caption    *string
+	Location   *string
+	Generation *Generation
+}
+
+// Generation describes how an AI-generated photo was made.
+type Generation struct {
+	Prompt string `json:"prompt"`
+	Model  string `json:"model"`
+	Seed   int    `json:"seed"`
 }
 
 type ImageBytes struct {
@@ -47,7 +64,7 @@ type SubImager interface {
 	SubImage(r image.Rectangle) image.Image
 }
 
-func getS3Client() *s3.Client {
+func getS3Client(ctx context.Context) (*s3.Client, error) {
 	accessKeyId := os.Getenv("AWS_ACCESS_KEY_ID")
 	accessKeySecret := os.Getenv("AWS_SECRET_ACCESS_KEY")
 	bucketUrl := os.Getenv("BUCKET_URL")
@@ -61,21 +78,22 @@ func getS3Client() *s3.Client {
 		}, nil
 	})
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
+	cfg, err := config.LoadDefaultConfig(ctx,
 		config.WithEndpointResolverWithOptions(r2Resolver),
 		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyId, accessKeySecret, "")),
 		config.WithRegion("auto"),
 	)
 
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("failed to load s3 config: %w", err)
 	}
 
 	client := s3.NewFromConfig(cfg)
-	return client
+	return client, nil
 }
 
-func (p *Photo) Create() (msg string, err error) {
+func (p *Photo) Create(ctx context.Context) (msg string, err error) {
+	defer func() { metrics.PhotoUploads.Inc(metrics.Result(err)) }()
 
 	currentTime := time.Now()
 	p.Date = currentTime.Format("2006-01-02 15:04:05")
@@ -84,14 +102,17 @@ func (p *Photo) Create() (msg string, err error) {
 	hasher.Write([]byte(p.Date))
 	p.ID = fmt.Sprintf("%x", hasher.Sum(nil))
 
-	pBytes, err := processPhoto(p)
+	pBytes, err := processPhoto(ctx, p)
 	if err != nil {
 		return "", err
 	}
 
-	client := getS3Client()
+	client, err := getS3Client(ctx)
+	if err != nil {
+		return "", err
+	}
 
-	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket: aws.String(BucketName),
 		Key:    aws.String(path.Base(p.ID) + ".jpg"),
 		Body:   bytes.NewReader(pBytes.Original),
@@ -100,7 +121,7 @@ func (p *Photo) Create() (msg string, err error) {
 		return "", err
 	}
 
-	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket: aws.String(BucketName),
 		Key:    aws.String(path.Base(p.ID) + ".webp"),
 		Body:   bytes.NewReader(pBytes.Thumbnail),
@@ -110,21 +131,23 @@ func (p *Photo) Create() (msg string, err error) {
 	}
 
 	jsonBytes, err := json.Marshal(struct {
-		Caption  *string `json:"caption,omitempty"`
-		Location *string `json:"location,omitempty"`
-		Date     string  `json:"date"`
-		ID       string  `json:"id"`
+		Caption    *string     `json:"caption,omitempty"`
+		Location   *string     `json:"location,omitempty"`
+		Date       string      `json:"date"`
+		ID         string      `json:"id"`
+		Generation *Generation `json:"generation,omitempty"`
 	}{
-		Caption:  p.Caption,
-		Location: p.Location,
-		Date:     p.Date,
-		ID:       p.ID,
+		Caption:    p.Caption,
+		Location:   p.Location,
+		Date:       p.Date,
+		ID:         p.ID,
+		Generation: p.Generation,
 	})
 	if err != nil {
 		return "", err
 	}
 
-	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket: aws.String(BucketName),
 		Key:    aws.String(path.Base(p.ID) + ".json"),
 		Body:   bytes.NewReader(jsonBytes),
@@ -134,31 +157,37 @@ func (p *Photo) Create() (msg string, err error) {
 	}
 
 	msg = fmt.Sprintf("Created photo with ID: %s", path.Base(p.ID))
-	triggerDeployment()
+	if err := triggerDeployment(ctx); err != nil {
+		logging.FromContext(ctx).Error("Error triggering deployment", "error", err)
+		msg += " (website deployment failed, trigger it manually)"
+	}
 
 	return msg, nil
 
 }
 
-func processPhoto(p *Photo) (ImageBytes, error) {
-	resp, err := http.Get(p.Url)
-	if err != nil {
-		return ImageBytes{}, fmt.Errorf("failed to get photo: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return ImageBytes{}, fmt.Errorf("unexpected status code: %s", resp.Status)
+func processPhoto(ctx context.Context, p *Photo) (ImageBytes, error) {
+	photoBytes := p.Data
+	if photoBytes == nil {
+		var err error
+		photoBytes, err = downloadPhoto(ctx, p.Url)
+		if err != nil {
+			return ImageBytes{}, err
+		}
 	}
 
-	photoBytes, err := io.ReadAll(resp.Body)
+	img, format, err := image.Decode(bytes.NewReader(photoBytes))
 	if err != nil {
-		return ImageBytes{}, fmt.Errorf("failed to read photo body: %w", err)
+		return ImageBytes{}, fmt.Errorf("failed to decode photo: %w", err)
 	}
 
-	img, _, err := image.Decode(bytes.NewReader(photoBytes))
-	if err != nil {
-		return ImageBytes{}, fmt.Errorf("failed to decode photo: %w", err)
+	// the original is stored as .jpg, so other formats are converted
+	if format != "jpeg" {
+		var jpegBytes bytes.Buffer
+		if err := jpeg.Encode(&jpegBytes, img, &jpeg.Options{Quality: 95}); err != nil {
+			return ImageBytes{}, fmt.Errorf("failed to encode photo to JPEG: %w", err)
+		}
+		photoBytes = jpegBytes.Bytes()
 	}
 
 	// generate square thumbnail
@@ -177,7 +206,7 @@ func processPhoto(p *Photo) (ImageBytes, error) {
 		SubImage(r image.Rectangle) image.Image
 	})
 	if !ok {
-		log.Fatal("Image does not support sub-imaging")
+		return ImageBytes{}, fmt.Errorf("image does not support sub-imaging")
 	}
 	croppedImg := croppedImage.SubImage(cropSize)
 
@@ -191,50 +220,168 @@ func processPhoto(p *Photo) (ImageBytes, error) {
 		Thumbnail: webpBytes.Bytes(),
 	}
 
-	log.Println("Successfully converted to WebP format")
+	logging.FromContext(ctx).Info("Successfully converted to WebP format", "photo_id", p.ID)
 
 	return imageData, nil
 
 }
 
-func DeletePhoto(id string) (msg string, err error) {
-	client := getS3Client()
+func downloadPhoto(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create photo request: %w", err)
+	}
 
-	objs, err := client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: aws.String(BucketName),
-		Prefix: aws.String(id),
-	})
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get photo: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %s", resp.Status)
+	}
+
+	photoBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read photo body: %w", err)
+	}
+	return photoBytes, nil
+}
+
+// photoID matches the IDs Create gives photos, the hex SHA-1 of their upload time.
+var photoID = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// photoExtensions are the objects kept for each photo: the original, its thumbnail and its metadata.
+var photoExtensions = []string{".jpg", ".webp", ".json"}
+
+// DeletePhoto deletes the objects of the photo with exactly this ID. Anything else,
+// such as part of an ID, is refused instead of deleting every photo it prefixes.
+func DeletePhoto(ctx context.Context, id string) (msg string, err error) {
+	if !photoID.MatchString(id) {
+		return "", fmt.Errorf("%q is not a photo ID", id)
+	}
+
+	client, err := getS3Client(ctx)
 	if err != nil {
 		return "", err
 	}
 
-	if len(objs.Contents) == 0 {
+	_, err = client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(BucketName),
+		Key:    aws.String(id + ".json"),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
 		return "No photos found with that ID", nil
 	}
+	if err != nil {
+		return "", err
+	}
 
-	for _, obj := range objs.Contents {
-		_, delErr := client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+	for _, ext := range photoExtensions {
+		_, delErr := client.DeleteObject(ctx, &s3.DeleteObjectInput{
 			Bucket: aws.String(BucketName),
-			Key:    obj.Key,
+			Key:    aws.String(id + ext),
 		})
 		if delErr != nil {
 			return "", delErr
 		}
 	}
 
-	msg = fmt.Sprintf("Deleted %d objects", len(objs.Contents))
-	triggerDeployment()
+	msg = fmt.Sprintf("Deleted photo %s", id)
+	if err := triggerDeployment(ctx); err != nil {
+		logging.FromContext(ctx).Error("Error triggering deployment", "error", err)
+		msg += " (website deployment failed, trigger it manually)"
+	}
 	return msg, nil
 
 }
 
-func triggerDeployment() {
+// ListPhotos returns up to limit photos from the gallery, newest first.
+func ListPhotos(ctx context.Context, limit int) ([]Photo, error) {
+	client, err := getS3Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata []types.Object
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{Bucket: aws.String(BucketName)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			if strings.HasSuffix(aws.ToString(obj.Key), ".json") {
+				metadata = append(metadata, obj)
+			}
+		}
+	}
+
+	sort.Slice(metadata, func(i, j int) bool {
+		return aws.ToTime(metadata[i].LastModified).After(aws.ToTime(metadata[j].LastModified))
+	})
+	if limit > 0 && len(metadata) > limit {
+		metadata = metadata[:limit]
+	}
+
+	photos := make([]Photo, 0, len(metadata))
+	for _, obj := range metadata {
+		resp, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(BucketName), Key: obj.Key})
+		if err != nil {
+			return nil, err
+		}
+
+		var p struct {
+			Caption    *string     `json:"caption"`
+			Location   *string     `json:"location"`
+			Date       string      `json:"date"`
+			ID         string      `json:"id"`
+			Generation *Generation `json:"generation"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&p)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", aws.ToString(obj.Key), err)
+		}
+
+		photos = append(photos, Photo{ID: p.ID, Date: p.Date, Caption: p.Caption, Location: p.Location, Generation: p.Generation})
+	}
+
+	return photos, nil
+}
+
+// Ping checks that the bucket is reachable with the configured credentials.
+func Ping(ctx context.Context) error {
+	client, err := getS3Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(BucketName)})
+	return err
+}
+
+func triggerDeployment(ctx context.Context) (err error) {
+	defer func() { metrics.DeployHooks.Inc(metrics.Result(err)) }()
+
 	url := os.Getenv("WEBSITE_HOOK")
-	resp, err := http.Post(url, "application/json", nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to create website hook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call website hook: %w", err)
 	}
 	defer resp.Body.Close()
 
-	log.Println("Response status:", resp.Status)
+	logging.FromContext(ctx).Info("Triggered website deployment", "status", resp.Status)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("website hook returned %s", resp.Status)
+	}
+	return nil
 }
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeBucket serves the S3 requests DeletePhoto makes, recording them.
+type fakeBucket struct {
+	mu       sync.Mutex
+	objects  map[string]bool
+	requests []string
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.requests = append(b.requests, r.Method+" "+r.URL.Path)
+	key := strings.TrimPrefix(r.URL.Path, "/"+BucketName+"/")
+	switch r.Method {
+	case http.MethodHead:
+		if !b.objects[key] {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case http.MethodDelete:
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		// the website hook
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newFakeBucket(t *testing.T, keys ...string) *fakeBucket {
+	b := &fakeBucket{objects: map[string]bool{}}
+	for _, key := range keys {
+		b.objects[key] = true
+	}
+	srv := httptest.NewServer(b)
+	t.Cleanup(srv.Close)
+
+	t.Setenv("BUCKET_URL", srv.URL)
+	t.Setenv("WEBSITE_HOOK", srv.URL+"/hook")
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	return b
+}
+
+const testPhotoID = "3f786850e387550fdab836ed7e6dc881de23001b"
+
+func TestDeletePhoto(t *testing.T) {
+	other := "3f8a0e9cd1b7b5b4d1d2c5c2b3d4e5f6a7b8c9d0"
+	b := newFakeBucket(t,
+		testPhotoID+".jpg", testPhotoID+".webp", testPhotoID+".json",
+		other+".jpg", other+".webp", other+".json",
+	)
+
+	msg, err := DeletePhoto(context.Background(), testPhotoID)
+	if err != nil {
+		t.Fatalf("DeletePhoto: %v", err)
+	}
+	if !strings.Contains(msg, testPhotoID) {
+		t.Errorf("message = %q, want the deleted ID", msg)
+	}
+	for key := range b.objects {
+		if strings.HasPrefix(key, testPhotoID) {
+			t.Errorf("%s was not deleted", key)
+		}
+	}
+	if len(b.objects) != 3 {
+		t.Errorf("objects left = %v, want the other photo's", b.objects)
+	}
+}
+
+func TestDeletePhotoPartialID(t *testing.T) {
+	for _, id := range []string{"", "a", "3f", testPhotoID[:39], testPhotoID + "0", strings.ToUpper(testPhotoID), "../" + testPhotoID[3:]} {
+		t.Run(id, func(t *testing.T) {
+			b := newFakeBucket(t, testPhotoID+".jpg", testPhotoID+".webp", testPhotoID+".json")
+
+			if _, err := DeletePhoto(context.Background(), id); err == nil {
+				t.Error("DeletePhoto succeeded, want an error")
+			}
+			if len(b.requests) != 0 || len(b.objects) != 3 {
+				t.Errorf("requests = %v, objects = %v, want nothing deleted", b.requests, b.objects)
+			}
+		})
+	}
+}
+
+func TestDeletePhotoMissing(t *testing.T) {
+	b := newFakeBucket(t, testPhotoID+".jpg")
+
+	msg, err := DeletePhoto(context.Background(), testPhotoID)
+	if err != nil {
+		t.Fatalf("DeletePhoto: %v", err)
+	}
+	if !strings.Contains(msg, "No photos found") {
+		t.Errorf("message = %q, want no photos found", msg)
+	}
+	if !b.objects[testPhotoID+".jpg"] {
+		t.Error("the objects of a photo without metadata were deleted")
+	}
+}
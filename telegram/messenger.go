@@ -0,0 +1,42 @@
+package telegram
+
+import (
+	"fmt"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Messenger is the part of the Telegram Bot API the modes talk to, so flows can run
+// against the real bot or against a fake in tests.
+type Messenger interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+	GetFile(config tgbotapi.FileConfig) (tgbotapi.File, error)
+	SendMediaGroup(config tgbotapi.MediaGroupConfig) ([]tgbotapi.Message, error)
+	// FileURL returns the download URL of a file fetched with GetFile.
+	FileURL(file tgbotapi.File) string
+	// Updates delivers every update the bot receives, in order.
+	Updates() tgbotapi.UpdatesChannel
+}
+
+// Bot is a Messenger backed by the Telegram Bot API.
+type Bot struct {
+	*tgbotapi.BotAPI
+
+	// FileEndpoint is a format string taking the token and the file path.
+	FileEndpoint string
+
+	updates tgbotapi.UpdatesChannel
+}
+
+func NewBot(api *tgbotapi.BotAPI, updates tgbotapi.UpdatesChannel) *Bot {
+	return &Bot{BotAPI: api, FileEndpoint: tgbotapi.FileEndpoint, updates: updates}
+}
+
+func (b *Bot) FileURL(file tgbotapi.File) string {
+	return fmt.Sprintf(b.FileEndpoint, b.Token, file.FilePath)
+}
+
+func (b *Bot) Updates() tgbotapi.UpdatesChannel {
+	return b.updates
+}
@@ -0,0 +1,262 @@
+// Package telegramtest provides Telegram test doubles: an in-memory Messenger and an
+// httptest server speaking the Bot API, so conversations can be scripted without network.
+package telegramtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const DefaultTimeout = 2 * time.Second
+
+// Fake is an in-memory telegram.Messenger that records everything the bot sends.
+type Fake struct {
+	ChatID   int64
+	UserName string
+	// FileBaseURL is prepended to file paths by FileURL, e.g. the URL of a Server.
+	FileBaseURL string
+
+	mu       sync.Mutex
+	sent     []tgbotapi.Chattable
+	cursor   int
+	files    map[string]tgbotapi.File
+	updates  chan tgbotapi.Update
+	notify   chan struct{}
+	updateID int
+	msgID    int
+	closed   bool
+}
+
+func NewFake(chatID int64, userName string) *Fake {
+	return &Fake{
+		ChatID:      chatID,
+		UserName:    userName,
+		FileBaseURL: "https://files.invalid",
+		files:       map[string]tgbotapi.File{},
+		updates:     make(chan tgbotapi.Update, 100),
+		notify:      make(chan struct{}, 1),
+	}
+}
+
+func (f *Fake) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.record(c)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.msgID++
+
+	msg := tgbotapi.Message{MessageID: f.msgID, Chat: &tgbotapi.Chat{ID: f.ChatID}, Date: int(time.Now().Unix())}
+	if m, ok := c.(tgbotapi.MessageConfig); ok {
+		msg.Text = m.Text
+	}
+	return msg, nil
+}
+
+func (f *Fake) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	f.record(c)
+	return &tgbotapi.APIResponse{Ok: true, Result: json.RawMessage("true")}, nil
+}
+
+func (f *Fake) GetFile(config tgbotapi.FileConfig) (tgbotapi.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, ok := f.files[config.FileID]
+	if !ok {
+		return tgbotapi.File{}, fmt.Errorf("Bad Request: invalid file_id")
+	}
+	return file, nil
+}
+
+func (f *Fake) SendMediaGroup(config tgbotapi.MediaGroupConfig) ([]tgbotapi.Message, error) {
+	f.record(config)
+
+	messages := make([]tgbotapi.Message, len(config.Media))
+	for i := range messages {
+		messages[i] = tgbotapi.Message{MessageID: i + 1, Chat: &tgbotapi.Chat{ID: f.ChatID}}
+	}
+	return messages, nil
+}
+
+func (f *Fake) FileURL(file tgbotapi.File) string {
+	return strings.TrimSuffix(f.FileBaseURL, "/") + "/" + file.FilePath
+}
+
+func (f *Fake) Updates() tgbotapi.UpdatesChannel {
+	return f.updates
+}
+
+// AddFile makes fileID resolvable by GetFile.
+func (f *Fake) AddFile(fileID, path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[fileID] = tgbotapi.File{FileID: fileID, FilePath: path}
+}
+
+// Push delivers an update to the bot.
+func (f *Fake) Push(update tgbotapi.Update) {
+	f.mu.Lock()
+	f.updateID++
+	update.UpdateID = f.updateID
+	f.mu.Unlock()
+
+	f.updates <- update
+}
+
+// PushText sends a text message from the user; text starting with / is sent as a command.
+func (f *Fake) PushText(text string) {
+	msg := f.NewMessage()
+	msg.Text = text
+	if strings.HasPrefix(text, "/") {
+		length := len(strings.Fields(text)[0])
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: length}}
+	}
+	f.Push(tgbotapi.Update{Message: msg})
+}
+
+// PushPhoto sends a photo the bot can download through fileID.
+func (f *Fake) PushPhoto(fileID, caption string) {
+	msg := f.NewMessage()
+	msg.Caption = caption
+	msg.Photo = []tgbotapi.PhotoSize{{FileID: fileID, Width: 1280, Height: 960}}
+	f.Push(tgbotapi.Update{Message: msg})
+}
+
+// PushVenue shares a venue, as the location step of the photo flow expects.
+func (f *Fake) PushVenue(title string) {
+	msg := f.NewMessage()
+	msg.Venue = &tgbotapi.Venue{Title: title}
+	f.Push(tgbotapi.Update{Message: msg})
+}
+
+// PushButton presses an inline button carrying data on a message in the fake's chat.
+func (f *Fake) PushButton(data string) {
+	msg := f.NewMessage()
+	f.Push(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      strconv.Itoa(msg.MessageID),
+		From:    msg.From,
+		Message: msg,
+		Data:    data,
+	}})
+}
+
+// Press presses the button labelled text on the last message the bot sent with one,
+// failing the test when there is none.
+func (f *Fake) Press(t testing.TB, text string) {
+	t.Helper()
+	sent := f.Sent()
+	for i := len(sent) - 1; i >= 0; i-- {
+		m, ok := sent[i].(tgbotapi.MessageConfig)
+		if !ok {
+			continue
+		}
+		keyboard, ok := m.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+		if !ok {
+			continue
+		}
+		for _, row := range keyboard.InlineKeyboard {
+			for _, button := range row {
+				if button.Text == text && button.CallbackData != nil {
+					f.PushButton(*button.CallbackData)
+					return
+				}
+			}
+		}
+	}
+	t.Fatalf("bot sent no button %q, sent: %q", text, f.Texts())
+}
+
+// NewMessage returns an empty message from the user in the fake's chat.
+func (f *Fake) NewMessage() *tgbotapi.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.msgID++
+
+	return &tgbotapi.Message{
+		MessageID: f.msgID,
+		From:      &tgbotapi.User{ID: f.ChatID, UserName: f.UserName},
+		Chat:      &tgbotapi.Chat{ID: f.ChatID, Type: "private"},
+		Date:      int(time.Now().Unix()),
+	}
+}
+
+// Close stops update delivery, as a shutdown would.
+func (f *Fake) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.closed = true
+		close(f.updates)
+	}
+}
+
+// Sent returns everything the bot sent or requested so far.
+func (f *Fake) Sent() []tgbotapi.Chattable {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]tgbotapi.Chattable{}, f.sent...)
+}
+
+// Texts returns the text of every message the bot sent so far.
+func (f *Fake) Texts() []string {
+	var texts []string
+	for _, c := range f.Sent() {
+		if m, ok := c.(tgbotapi.MessageConfig); ok {
+			texts = append(texts, m.Text)
+		}
+	}
+	return texts
+}
+
+// WaitForText waits until the bot sends a message containing substr, skipping messages
+// matched by previous calls, and returns its full text.
+func (f *Fake) WaitForText(substr string, timeout time.Duration) (string, error) {
+	deadline := time.After(timeout)
+
+	for {
+		f.mu.Lock()
+		for f.cursor < len(f.sent) {
+			c := f.sent[f.cursor]
+			f.cursor++
+			if m, ok := c.(tgbotapi.MessageConfig); ok && strings.Contains(m.Text, substr) {
+				f.mu.Unlock()
+				return m.Text, nil
+			}
+		}
+		f.mu.Unlock()
+
+		select {
+		case <-f.notify:
+		case <-deadline:
+			return "", fmt.Errorf("bot did not send %q within %s, sent: %q", substr, timeout, f.Texts())
+		}
+	}
+}
+
+// Expect is WaitForText with DefaultTimeout that fails the test when nothing matches.
+func (f *Fake) Expect(t testing.TB, substr string) string {
+	t.Helper()
+	text, err := f.WaitForText(substr, DefaultTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return text
+}
+
+func (f *Fake) record(c tgbotapi.Chattable) {
+	f.mu.Lock()
+	f.sent = append(f.sent, c)
+	f.mu.Unlock()
+
+	select {
+	case f.notify <- struct{}{}:
+	default:
+	}
+}
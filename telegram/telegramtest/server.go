@@ -0,0 +1,223 @@
+package telegramtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"duarteocarmo/ambrosio/telegram"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	Token       = "123456789:TESTTOKENtesttokenTESTTOKENtesttoken"
+	BotUserName = "ambrosio_test_bot"
+	// MaxPollWait caps getUpdates long polling so tests stay fast.
+	MaxPollWait = time.Second
+)
+
+var (
+	_ telegram.Messenger = (*Fake)(nil)
+	_ telegram.Messenger = (*telegram.Bot)(nil)
+)
+
+// Request is a Bot API call received by the Server.
+type Request struct {
+	Method string
+	Params url.Values
+}
+
+type serverFile struct {
+	path string
+	data []byte
+}
+
+// Server is an httptest server implementing the Bot API methods the bot uses,
+// plus file downloads, so the real tgbotapi client can run against it.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	updates  []tgbotapi.Update
+	updateID int
+	msgID    int
+	files    map[string]serverFile
+	requests []Request
+	pushed   chan struct{}
+}
+
+func NewServer() *Server {
+	s := &Server{files: map[string]serverFile{}, pushed: make(chan struct{}, 1)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// NewBot connects a telegram.Bot to the server, polling it for updates.
+func (s *Server) NewBot() (*telegram.Bot, error) {
+	api, err := tgbotapi.NewBotAPIWithClient(Token, s.URL+"/bot%s/%s", s.Client())
+	if err != nil {
+		return nil, err
+	}
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = int(MaxPollWait.Seconds())
+
+	bot := telegram.NewBot(api, api.GetUpdatesChan(u))
+	bot.FileEndpoint = s.URL + "/file/bot%s/%s"
+	return bot, nil
+}
+
+// PushUpdate queues an update for the next getUpdates call.
+func (s *Server) PushUpdate(update tgbotapi.Update) {
+	s.mu.Lock()
+	s.updateID++
+	update.UpdateID = s.updateID
+	s.updates = append(s.updates, update)
+	s.mu.Unlock()
+
+	select {
+	case s.pushed <- struct{}{}:
+	default:
+	}
+}
+
+// AddFile makes fileID resolvable through getFile and downloadable with data.
+func (s *Server) AddFile(fileID, path string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[fileID] = serverFile{path: path, data: data}
+}
+
+// Requests returns the calls received for method, or all calls if method is empty.
+func (s *Server) Requests(method string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var requests []Request
+	for _, r := range s.requests {
+		if method == "" || r.Method == method {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/file/bot"+Token+"/") {
+		s.serveFile(w, strings.TrimPrefix(r.URL.Path, "/file/bot"+Token+"/"))
+		return
+	}
+
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+Token+"/")
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// multipart uploads fall back to url-encoded parsing, so the error is ignored
+	r.ParseMultipartForm(32 << 20)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: method, Params: r.Form})
+	s.mu.Unlock()
+
+	switch method {
+	case "getMe":
+		writeResult(w, tgbotapi.User{ID: 1, IsBot: true, FirstName: "Ambrosio", UserName: BotUserName})
+	case "getUpdates":
+		offset, _ := strconv.Atoi(r.Form.Get("offset"))
+		writeResult(w, s.waitForUpdates(offset))
+	case "getFile":
+		s.mu.Lock()
+		file, ok := s.files[r.Form.Get("file_id")]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Bad Request: invalid file_id")
+			return
+		}
+		writeResult(w, tgbotapi.File{FileID: r.Form.Get("file_id"), FilePath: file.path, FileSize: len(file.data)})
+	case "sendMediaGroup":
+		var media []json.RawMessage
+		json.Unmarshal([]byte(r.Form.Get("media")), &media)
+		messages := make([]tgbotapi.Message, len(media))
+		for i := range messages {
+			messages[i] = s.newMessage(r.Form)
+		}
+		writeResult(w, messages)
+	case "sendMessage", "sendPhoto", "sendDocument", "sendVoice", "sendAudio", "editMessageText", "editMessageReplyMarkup":
+		writeResult(w, s.newMessage(r.Form))
+	default:
+		writeResult(w, true)
+	}
+}
+
+func (s *Server) waitForUpdates(offset int) []tgbotapi.Update {
+	deadline := time.After(MaxPollWait)
+
+	for {
+		s.mu.Lock()
+		var pending []tgbotapi.Update
+		for _, u := range s.updates {
+			if u.UpdateID >= offset {
+				pending = append(pending, u)
+			}
+		}
+		s.mu.Unlock()
+
+		if len(pending) > 0 {
+			return pending
+		}
+
+		select {
+		case <-s.pushed:
+		case <-deadline:
+			return []tgbotapi.Update{}
+		}
+	}
+}
+
+func (s *Server) newMessage(params url.Values) tgbotapi.Message {
+	s.mu.Lock()
+	s.msgID++
+	id := s.msgID
+	s.mu.Unlock()
+
+	chatID, _ := strconv.ParseInt(params.Get("chat_id"), 10, 64)
+	return tgbotapi.Message{
+		MessageID: id,
+		Chat:      &tgbotapi.Chat{ID: chatID},
+		Date:      int(time.Now().Unix()),
+		Text:      params.Get("text"),
+	}
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, file := range s.files {
+		if file.path == path {
+			w.Write(file.data)
+			return
+		}
+	}
+	http.NotFound(w, nil)
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	raw, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: raw})
+}
+
+func writeError(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: false, ErrorCode: code, Description: description})
+}
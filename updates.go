@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"sync"
+
+	"duarteocarmo/ambrosio/logging"
+	"duarteocarmo/ambrosio/metrics"
+	"duarteocarmo/ambrosio/modes"
+	"duarteocarmo/ambrosio/server"
+	"duarteocarmo/ambrosio/telegram"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// receiveUpdates returns the channel every update is dispatched from, fed either by
+// long polling or by the webhook handler on srv depending on UPDATES_MODE. The channel
+// is closed once ctx is done, so flows waiting for input return instead of blocking.
+func receiveUpdates(ctx context.Context, bot *tgbotapi.BotAPI, srv *server.Server) (tgbotapi.UpdatesChannel, error) {
+	m := os.Getenv("UPDATES_MODE")
+
+	switch m {
+	case "", PollingMode:
+		return pollUpdates(ctx, bot)
+	case WebhookMode:
+		return listenForWebhook(ctx, bot, srv)
+	default:
+		return nil, fmt.Errorf("unknown UPDATES_MODE %q, use %s or %s", m, PollingMode, WebhookMode)
+	}
+}
+
+func pollUpdates(ctx context.Context, bot *tgbotapi.BotAPI) (tgbotapi.UpdatesChannel, error) {
+	// Telegram refuses getUpdates while a webhook is registered
+	if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		return nil, fmt.Errorf("error deleting webhook: %w", err)
+	}
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = Timeout
+	polled := bot.GetUpdatesChan(u)
+
+	updates := make(chan tgbotapi.Update)
+	go func() {
+		defer close(updates)
+		defer bot.StopReceivingUpdates()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case update, ok := <-polled:
+				if !ok {
+					return
+				}
+				select {
+				case updates <- update:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	slog.Info("Receiving updates with long polling")
+	return updates, nil
+}
+
+func listenForWebhook(ctx context.Context, bot *tgbotapi.BotAPI, srv *server.Server) (tgbotapi.UpdatesChannel, error) {
+	publicURL := os.Getenv("WEBHOOK_URL")
+	secret := os.Getenv("WEBHOOK_SECRET")
+
+	if publicURL == "" || secret == "" {
+		return nil, fmt.Errorf("WEBHOOK_URL and WEBHOOK_SECRET must be set in %s mode", WebhookMode)
+	}
+
+	parsed, err := url.Parse(publicURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEBHOOK_URL: %w", err)
+	}
+	path := parsed.Path
+	if path == "" {
+		path = "/"
+	}
+
+	updates := make(chan tgbotapi.Update, bot.Buffer)
+	srv.Handle(path, server.WebhookHandler(ctx, secret, updates))
+
+	// the handler may send until the server has shut down
+	go func() {
+		<-srv.Done()
+		close(updates)
+	}()
+
+	if err := server.RegisterWebhook(bot, publicURL, secret); err != nil {
+		return nil, err
+	}
+
+	slog.Info("Receiving updates with webhook", "path", path)
+	return updates, nil
+}
+
+// router passes updates on to the main loop, except for those answered as they arrive:
+// inline queries, reminder buttons and everything from groups, which flows waiting for
+// messages would otherwise hold up or swallow. Group messages not addressed to the bot
+// are dropped, and so is everything from groups missing from the allowlist.
+type router struct {
+	bot       telegram.Messenger
+	stores    *Stores
+	inline    *modes.Inline
+	groups    *modes.GroupChats
+	allowlist groupAllowlist
+
+	// pending counts the updates being answered outside of the main loop
+	pending sync.WaitGroup
+}
+
+// run routes updates until the channel is closed, then closes messages.
+func (r *router) run(ctx context.Context, updates tgbotapi.UpdatesChannel, messages chan<- tgbotapi.Update) {
+	defer close(messages)
+
+	// group updates are answered in order, one at a time, apart from the private flows
+	groupUpdates := make(chan tgbotapi.Update, cap(messages))
+	defer close(groupUpdates)
+	r.pending.Add(1)
+	go func() {
+		defer r.pending.Done()
+		for update := range groupUpdates {
+			updateCtx := logging.WithCorrelationID(ctx, logging.NewCorrelationID(), "update_id", update.UpdateID)
+			if update.Message != nil && !update.Message.IsCommand() {
+				handleGroupMessage(updateCtx, update.Message, r.groups, r.allowlist)
+			} else {
+				// commands that start flows are private, so none waits on messages here
+				handleUpdate(updateCtx, update, r.bot, r.stores, r.allowlist)
+			}
+		}
+	}()
+
+	for update := range updates {
+		// counted here, as flows take their updates straight from messages
+		metrics.Updates.Inc(updateType(update))
+
+		switch {
+		case update.InlineQuery != nil:
+			query := update.InlineQuery
+			queryCtx := logging.WithCorrelationID(ctx, logging.NewCorrelationID(), "update_id", update.UpdateID)
+			r.goAnswer(func() { handleInlineQuery(queryCtx, query, r.inline) })
+
+		case update.CallbackQuery != nil && isReminderButton(update.CallbackQuery.Data):
+			button := update
+			buttonCtx := logging.WithCorrelationID(ctx, logging.NewCorrelationID(), "update_id", update.UpdateID)
+			r.goAnswer(func() { handleButton(buttonCtx, button, r.bot, r.stores, r.allowlist) })
+
+		case update.Message != nil && !update.Message.Chat.IsPrivate():
+			chat := update.Message.Chat
+			switch {
+			case !r.allowlist.has(chat.ID):
+				slog.Debug("Ignoring message from group not in TELEGRAM_GROUPS", "chat_id", chat.ID, "title", chat.Title)
+			// talk among members isn't for the bot
+			case !r.groups.Addressed(update.Message):
+			default:
+				groupUpdates <- update
+			}
+
+		case update.CallbackQuery != nil && update.CallbackQuery.Message != nil && !update.CallbackQuery.Message.Chat.IsPrivate():
+			groupUpdates <- update
+
+		default:
+			messages <- update
+		}
+	}
+}
+
+// goAnswer answers an update in the background, so run keeps routing meanwhile.
+func (r *router) goAnswer(answer func()) {
+	r.pending.Add(1)
+	go func() {
+		defer r.pending.Done()
+		answer()
+	}()
+}
+
+// wait blocks until the updates routed so far are answered.
+func (r *router) wait() {
+	r.pending.Wait()
+}
+
+func isReminderButton(data string) bool {
+	button, ok := modes.ParseButtonData(data)
+	return ok && button.Kind == modes.ButtonReminder
+}
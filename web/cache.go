@@ -0,0 +1,56 @@
+package web
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCacheSize = 50
+	DefaultCacheTTL  = time.Hour
+)
+
+type cacheEntry struct {
+	page    Page
+	expires time.Time
+}
+
+// Cache keeps recently fetched pages in memory, dropping the oldest when full.
+type Cache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	order   []string
+}
+
+func NewCache(size int, ttl time.Duration) *Cache {
+	return &Cache{size: size, ttl: ttl, entries: map[string]cacheEntry{}}
+}
+
+func (c *Cache) Get(url string) (Page, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[url]
+	if !ok || time.Now().After(e.expires) {
+		return Page{}, false
+	}
+	return e.page, true
+}
+
+func (c *Cache) Put(url string, page Page) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[url]; !ok {
+		c.order = append(c.order, url)
+	}
+	c.entries[url] = cacheEntry{page: page, expires: time.Now().Add(c.ttl)}
+
+	for len(c.order) > c.size {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
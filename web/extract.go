@@ -0,0 +1,137 @@
+package web
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// skipped are elements whose text is never part of the main content.
+var skipped = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Nav: true, atom.Header: true, atom.Footer: true, atom.Aside: true,
+	atom.Form: true, atom.Button: true, atom.Svg: true, atom.Iframe: true,
+}
+
+// blocks are elements that start a new paragraph.
+var blocks = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true, atom.Main: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Li: true, atom.Ul: true, atom.Ol: true, atom.Blockquote: true, atom.Pre: true,
+	atom.Table: true, atom.Tr: true, atom.Br: true, atom.Figcaption: true, atom.Dd: true, atom.Dt: true,
+}
+
+// ExtractText returns the title and readable text of an HTML page, taken from its
+// <article> or <main> element when it has one, leaving out navigation and scripts.
+func ExtractText(page []byte) (string, string, error) {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return "", "", err
+	}
+
+	title := ""
+	if n := find(doc, atom.Title); n != nil {
+		title = strings.TrimSpace(textOf(n))
+	}
+	if og := findMeta(doc, "og:title"); og != "" {
+		title = og
+	}
+
+	root := find(doc, atom.Article)
+	if root == nil {
+		root = find(doc, atom.Main)
+	}
+	if root == nil {
+		root = find(doc, atom.Body)
+	}
+	if root == nil {
+		root = doc
+	}
+
+	var b strings.Builder
+	render(&b, root)
+	return title, normalise(b.String()), nil
+}
+
+func render(b *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		b.WriteString(n.Data)
+		return
+	case html.ElementNode:
+		if skipped[n.DataAtom] {
+			return
+		}
+		if blocks[n.DataAtom] {
+			b.WriteString("\n\n")
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		render(b, c)
+	}
+
+	if n.Type == html.ElementNode && blocks[n.DataAtom] {
+		b.WriteString("\n\n")
+	}
+}
+
+// normalise collapses whitespace within paragraphs and drops empty ones.
+func normalise(text string) string {
+	var paragraphs []string
+	for _, p := range strings.Split(text, "\n\n") {
+		p = strings.Join(strings.Fields(p), " ")
+		if p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+func find(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := find(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func findMeta(n *html.Node, property string) string {
+	if n.Type == html.ElementNode && n.DataAtom == atom.Meta {
+		var prop, content string
+		for _, attr := range n.Attr {
+			switch attr.Key {
+			case "property", "name":
+				prop = attr.Val
+			case "content":
+				content = attr.Val
+			}
+		}
+		if prop == property {
+			return strings.TrimSpace(content)
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findMeta(c, property); found != "" {
+			return found
+		}
+	}
+	return ""
+}
+
+func textOf(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textOf(c))
+	}
+	return b.String()
+}
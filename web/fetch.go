@@ -0,0 +1,150 @@
+// Package web fetches pages and extracts their readable text.
+package web
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	FetchTimeout = 15 * time.Second
+	// MaxPageSize is the most bytes read from a page; longer pages are cut.
+	MaxPageSize = 2 << 20
+	UserAgent   = "Mozilla/5.0 (compatible; Ambrosio/1.0; +https://github.com/duarteocarmo/ambrosio)"
+)
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// Page is the readable content of a web page.
+type Page struct {
+	URL   string
+	Title string
+	Text  string
+}
+
+// FindURLs returns the http and https URLs in text.
+func FindURLs(text string) []string {
+	var urls []string
+	for _, match := range urlPattern.FindAllString(text, -1) {
+		match = strings.TrimRight(match, ".,;:!?)]}'")
+		if u, err := url.Parse(match); err == nil && u.Host != "" {
+			urls = append(urls, match)
+		}
+	}
+	return urls
+}
+
+// Fetcher downloads pages, caching them by URL.
+type Fetcher struct {
+	Client *http.Client
+	Cache  *Cache
+}
+
+// NewFetcher returns a Fetcher that only connects to public addresses, so a URL can't
+// make the bot reach its own server, the local network or cloud metadata endpoints.
+// The check runs on every connection, redirects included, after the host is resolved.
+func NewFetcher() *Fetcher {
+	dialer := &net.Dialer{Timeout: FetchTimeout, Control: dialPublic}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be dialed instead of the page's host, skipping the check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &Fetcher{
+		Client: &http.Client{Timeout: FetchTimeout, Transport: transport},
+		Cache:  NewCache(DefaultCacheSize, DefaultCacheTTL),
+	}
+}
+
+// dialPublic refuses connections to loopback, private, link-local and other non-public addresses.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("unexpected address %q: %w", host, err)
+	}
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return fmt.Errorf("%s is not a public address", ip)
+	}
+	return nil
+}
+
+// Fetch returns the page at rawURL from the cache, or downloads and extracts it.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (Page, error) {
+	if page, ok := f.Cache.Get(rawURL); ok {
+		return page, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Page{}, fmt.Errorf("%q is not a web address", rawURL)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, FetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return Page{}, err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("Accept", "text/html,text/plain;q=0.9")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return Page{}, fmt.Errorf("error fetching %s: %w", u.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Page{}, fmt.Errorf("%s answered %s", u.Host, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxPageSize))
+	if err != nil {
+		return Page{}, fmt.Errorf("error reading %s: %w", u.Host, err)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	page := Page{URL: rawURL}
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml" || mediaType == "":
+		page.Title, page.Text, err = ExtractText(body)
+		if err != nil {
+			return Page{}, fmt.Errorf("error reading %s: %w", u.Host, err)
+		}
+	case strings.HasPrefix(mediaType, "text/"):
+		if !utf8.Valid(body) {
+			return Page{}, fmt.Errorf("%s is not UTF-8 text", rawURL)
+		}
+		page.Text = string(body)
+	default:
+		return Page{}, fmt.Errorf("%s is a %s, not a web page", rawURL, mediaType)
+	}
+
+	page.Text = strings.TrimSpace(page.Text)
+	if page.Text == "" {
+		return Page{}, fmt.Errorf("no readable text found at %s", rawURL)
+	}
+	if page.Title == "" {
+		page.Title = u.Host + u.Path
+	}
+
+	f.Cache.Put(rawURL, page)
+	return page, nil
+}